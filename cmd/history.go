@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/pivot"
+	"github.com/spf13/cobra"
+)
+
+// flag storage
+var historyOutput string
+
+// HistoryCmd houses the cobra config for the history command
+var HistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Shows the pivot attempts made on the system",
+	Args:  cobra.NoArgs,
+	Run:   ExecuteHistory,
+}
+
+// init executes upon import
+func init() {
+	HistoryCmd.Flags().StringVarP(&historyOutput, "output", "o", "table", "Output format: table or json")
+	RootCmd.AddCommand(HistoryCmd)
+}
+
+// formatKargsChanges summarizes the kernel argument changes of an attempt
+// as +added, -deleted and key=old->new
+func formatKargsChanges(entry pivot.HistoryEntry) string {
+	changes := []string{}
+	for _, arg := range entry.KernelArgsAdded {
+		changes = append(changes, "+"+arg.String())
+	}
+	for _, arg := range entry.KernelArgsDeleted {
+		changes = append(changes, "-"+arg.String())
+	}
+	for _, replacement := range entry.KernelArgsReplaced {
+		changes = append(changes, fmt.Sprintf("%s->%s", replacement.Old(), replacement.Value))
+	}
+	if len(changes) == 0 {
+		return "-"
+	}
+	return strings.Join(changes, " ")
+}
+
+// orDash returns s, or - if it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// printHistory writes out the history in the requested format
+func printHistory(w io.Writer, entries []pivot.HistoryEntry, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "STARTED\tDURATION\tOUTCOME\tVERSION\tCOMMIT\tIMAGE\tKERNEL ARGS\tERROR")
+		for _, entry := range entries {
+			commit := entry.Commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+			image := entry.ImageID
+			if image == "" {
+				image = entry.Pullspec
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				entry.Started.Format(time.RFC3339),
+				entry.Duration().Round(time.Second),
+				entry.Outcome,
+				orDash(entry.Version),
+				orDash(commit),
+				orDash(image),
+				formatKargsChanges(entry),
+				orDash(entry.Error))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	return nil
+}
+
+// ExecuteHistory runs the history command
+func ExecuteHistory(cmd *cobra.Command, args []string) {
+	entries, err := pivot.ReadHistory(pivot.HistoryFile)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	if err := printHistory(os.Stdout, entries, historyOutput); err != nil {
+		glog.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/pivot"
+	"github.com/openshift/pivot/utils"
+	"github.com/spf13/cobra"
+)
+
+// RebootCmd houses the cobra config for the reboot command
+var RebootCmd = &cobra.Command{
+	Use:   "reboot",
+	Short: "Runs the pre-reboot hooks and reboots, now or as --reboot-policy says",
+	Args:  cobra.NoArgs,
+	Run:   ExecuteReboot,
+}
+
+// init executes upon import
+func init() {
+	RootCmd.AddCommand(RebootCmd)
+}
+
+// ExecuteReboot runs the reboot command. It is also what a reboot scheduled
+// by the delayed and maintenance policies runs.
+func ExecuteReboot(cmd *cobra.Command, args []string) {
+	policy := pivot.RebootPolicy{Mode: pivot.RebootImmediate}
+	if rebootPolicy != "" {
+		var err error
+		if policy, err = pivot.ParseRebootPolicy(rebootPolicy); err != nil {
+			glog.Fatalf("%v", err)
+		}
+	}
+	rebootSystem(utils.NewRunner(nil), policy)
+}
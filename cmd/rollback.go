@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/pivot"
+	"github.com/openshift/pivot/utils"
+	"github.com/spf13/cobra"
+)
+
+// flag storage
+var force bool
+
+// RollbackCmd houses the cobra config for the rollback command
+var RollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Rolls back to the previous pivot deployment",
+	Args:  cobra.NoArgs,
+	Run:   ExecuteRollback,
+}
+
+// init executes upon import
+func init() {
+	RollbackCmd.Flags().BoolVarP(&force, "force", "f", false, "Roll back even if the target is not managed by pivot")
+	RootCmd.AddCommand(RollbackCmd)
+}
+
+// ExecuteRollback runs the rollback command
+func ExecuteRollback(cmd *cobra.Command, args []string) {
+	policy := mustGetRebootPolicy()
+	r := utils.NewRunner(nil)
+	res, err := pivot.Rollback(pivot.RollbackOptions{
+		Force:      force,
+		TargetFile: pivot.TargetFile,
+		Runner:     r,
+	})
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	glog.Infof("Rolled back from %s to %s", res.From.Checksum, res.To.Checksum)
+	rebootSystem(r, policy)
+}
@@ -1,25 +1,24 @@
 package cmd
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"strings"
-
-	// Enable sha256 in container image references
-	_ "crypto/sha256"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/pivot"
+	"github.com/openshift/pivot/pkg/registry"
+	"github.com/openshift/pivot/pkg/signature"
 	"github.com/openshift/pivot/types"
 	"github.com/openshift/pivot/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"k8s.io/apimachinery/pkg/util/uuid"
-
-	imgref "github.com/containers/image/docker/reference"
 )
 
 // flag storage
@@ -27,24 +26,14 @@ var keep bool
 var reboot bool
 var container string
 var exit_77 bool
-
-const (
-	// the number of times to retry commands that pull data from the network
-	numRetriesNetCommands = 5
-	etcPivotFile          = "/etc/pivot/image-pullspec"
-	runPivotRebootFile    = "/run/pivot/reboot-needed"
-	// Pull secret.  Written by the machine-config-operator
-	kubeletAuthFile = "/var/lib/kubelet/config.json"
-	// File containing kernel arg changes for tuning
-	kernelTuningFile = "/etc/pivot/kernel-args"
-	cmdLineFile      = "/proc/cmdline"
-)
-
-// TODO: fill out the whitelist
-// tuneableArgsWhitelist contains allowed keys for tunable arguments
-var tuneableArgsWhitelist = map[string]bool{
-	"nosmt": true,
-}
+var dryRun bool
+var imageProvider string
+var signaturePolicy string
+var ignorePlatform bool
+var ostreeRef string
+var ostreeCommit string
+var strict bool
+var rebootPolicy string
 
 // RootCmd houses the cobra config for the main command
 var RootCmd = &cobra.Command{
@@ -59,365 +48,307 @@ var RootCmd = &cobra.Command{
 func init() {
 	RootCmd.PersistentFlags().BoolVarP(&keep, "keep", "k", false, "Do not remove container image")
 	RootCmd.PersistentFlags().BoolVarP(&reboot, "reboot", "r", false, "Reboot if changed")
+	RootCmd.PersistentFlags().StringVar(&rebootPolicy, "reboot-policy", "", "How to reboot: never, immediate, delayed:MINUTES, maintenance:[DAY,...] HH:MM-HH:MM or marker")
 	RootCmd.PersistentFlags().BoolVar(&exit_77, "unchanged-exit-77", false, "If unchanged, exit 77")
+	RootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would be done without changing the system")
+	RootCmd.Flags().StringVar(&imageProvider, "image-provider", "", "How images are fetched: podman or registry (default podman if installed)")
+	RootCmd.Flags().StringVar(&signaturePolicy, "signature-policy", pivot.SignaturePolicyFile, "The signature policy images must satisfy before rebasing, empty to not verify images")
+	RootCmd.Flags().BoolVar(&ignorePlatform, "ignore-platform", false, "Rebase even if the image is for another architecture or OS")
+	RootCmd.Flags().StringVar(&ostreeRef, "ref", "", "Rebase to the commit of this OSTree ref in the image")
+	RootCmd.Flags().StringVar(&ostreeCommit, "commit", "", "Rebase to this OSTree commit in the image")
+	RootCmd.Flags().BoolVar(&strict, "strict", false, "Fail before changing anything if the kernel tuning file has invalid lines")
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 }
 
-// isArgTuneable returns if the argument provided is allowed to be modified
-func isArgTunable(arg string) bool {
-	return tuneableArgsWhitelist[arg]
+// formatArgs returns the tuning arguments as they appear on the kernel command line
+func formatArgs(args []types.TuneArgument) string {
+	if len(args) == 0 {
+		return "(none)"
+	}
+	formatted := []string{}
+	for _, arg := range args {
+		formatted = append(formatted, arg.String())
+	}
+	return strings.Join(formatted, " ")
 }
 
-// isArgInUse checks to see if the argument is already in use by the system currently
-func isArgInUse(arg, cmdLinePath string) (bool, error) {
-	if cmdLinePath == "" {
-		cmdLinePath = cmdLineFile
+// formatReplacements formats kernel argument replacements as old -> new
+func formatReplacements(replacements []types.TuneReplacement) string {
+	if len(replacements) == 0 {
+		return "(none)"
 	}
-	content, err := ioutil.ReadFile(cmdLinePath)
-	if err != nil {
-		return false, err
+	formatted := []string{}
+	for _, replacement := range replacements {
+		formatted = append(formatted, fmt.Sprintf("%s -> %s", replacement.Old(), replacement.New()))
 	}
+	return strings.Join(formatted, ", ")
+}
 
-	checkable := string(content)
-	if strings.Contains(checkable, arg) {
-		return true, nil
+// formatAppliedTuning describes the record of the last tuning file applied
+func formatAppliedTuning(applied *pivot.AppliedTuning) string {
+	if applied == nil {
+		return "(none)"
+	}
+	checksum := applied.Checksum
+	if len(checksum) > 12 {
+		checksum = checksum[:12]
+	}
+	s := fmt.Sprintf("%s %s (sha256:%s) at %s", applied.Outcome, applied.File, checksum, applied.Time.Format(time.RFC3339))
+	if applied.Error != "" {
+		s += ": " + applied.Error
 	}
-	return false, nil
+	return s
 }
 
-// parseTuningFile parses the kernel argument tuning file
-func parseTuningFile(tuningFilePath, cmdLinePath string) ([]types.TuneArgument, []types.TuneArgument, error) {
-	addArguments := []types.TuneArgument{}
-	deleteArguments := []types.TuneArgument{}
-	if tuningFilePath == "" {
-		tuningFilePath = kernelTuningFile
-	}
-	if cmdLinePath == "" {
-		cmdLinePath = cmdLineFile
-	}
-	// Return fast if the file does not exist
-	if _, err := os.Stat(tuningFilePath); os.IsNotExist(err) {
-		glog.V(2).Infof("no kernel tuning needed as %s does not exist", tuningFilePath)
-		// This isn't an error. Return out.
-		return addArguments, deleteArguments, err
-	}
-	// Read and parse the file
-	file, err := os.Open(tuningFilePath)
-	if err != nil {
-		// If we have an issue reading return an error
-		glog.Infof("Unable to open %s for reading: %v", tuningFilePath, err)
-		return addArguments, deleteArguments, err
+// printPlan writes out what a dry run found would be done
+func printPlan(w io.Writer, res pivot.Result, rebootRequested bool) {
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	commit := res.Commit
+	if commit == "" {
+		commit = "(unknown until the image is pulled)"
+	}
+	fmt.Fprintf(w, "Image:              %s\n", res.ImageID)
+	fmt.Fprintf(w, "Digest:             %s\n", res.Digest)
+	fmt.Fprintf(w, "OSTree commit:      %s\n", commit)
+	if res.Version != "" {
+		fmt.Fprintf(w, "Version:            %s\n", res.Version)
+	}
+	fmt.Fprintf(w, "Rebase:             %s\n", yesNo[res.Rebased])
+	fmt.Fprintf(w, "Kernel args to add: %s\n", formatArgs(res.KernelArgsAdded))
+	fmt.Fprintf(w, "Kernel args to del: %s\n", formatArgs(res.KernelArgsDeleted))
+	fmt.Fprintf(w, "Kernel args to set: %s\n", formatReplacements(res.KernelArgsReplaced))
+	if res.TuningSkipped {
+		fmt.Fprintf(w, "Kernel tuning:      unchanged, %s\n", formatAppliedTuning(res.KernelTuning))
 	}
-	// Clean up
-	defer file.Close()
+	fmt.Fprintf(w, "Reboot:             %s\n", yesNo[res.RebootRequired && rebootRequested])
+}
 
-	// Parse the tuning lines
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "ADD ") {
-			// NOTE: Today only specific bare kernel arguments are allowed so
-			// there is not a need to split on =.
-			key := strings.TrimSpace(line[len("ADD "):])
-			if isArgTunable(key) {
-				// Find out if the argument is in use
-				inUse, err := isArgInUse(key, cmdLinePath)
-				if err != nil {
-					return addArguments, deleteArguments, err
-				}
-				if !inUse {
-					addArguments = append(addArguments, types.TuneArgument{Key: key, Bare: true})
-				} else {
-					glog.Infof(`skipping "%s" as it is already in use`, key)
-				}
-			} else {
-				glog.Infof("%s not a whitelisted kernel argument", key)
-			}
-		} else if strings.HasPrefix(line, "DELETE ") {
-			// NOTE: Today only specific bare kernel arguments are allowed so
-			// there is not a need to split on =.
-			key := strings.TrimSpace(line[len("DELETE "):])
-			if isArgTunable(key) {
-				inUse, err := isArgInUse(key, cmdLinePath)
-				if err != nil {
-					return addArguments, deleteArguments, err
-				}
-				if inUse {
-					deleteArguments = append(deleteArguments, types.TuneArgument{Key: key, Bare: true})
-				} else {
-					glog.Infof(`skipping "%s" as it is not present in the current argument list`, key)
-				}
-			} else {
-				glog.Infof("%s not a whitelisted kernel argument", key)
-			}
-		} else {
-			glog.V(2).Infof(`skipping malformed line in %s: "%s"`, tuningFilePath, line)
+// newImageProvider returns the named pivot.ImageProvider. Without a name
+// podman is used if it is installed.
+func newImageProvider(name string, r *utils.Runner, reg *registry.Client) (pivot.ImageProvider, error) {
+	if name == "" {
+		name = "registry"
+		if _, err := exec.LookPath("podman"); err == nil {
+			name = "podman"
 		}
 	}
-	return addArguments, deleteArguments, nil
+	switch name {
+	case "podman":
+		return pivot.NewPodmanProvider(r), nil
+	case "registry":
+		return pivot.NewRegistryProvider(reg, ""), nil
+	}
+	return nil, fmt.Errorf("unknown image provider %q", name)
 }
 
-// updateTuningArgs executes additions and removals of kernel tuning arguments
-func updateTuningArgs(tuningFilePath, cmdLinePath string) (bool, error) {
-	if cmdLinePath == "" {
-		cmdLinePath = cmdLineFile
+// newVerifier loads the signature policy at path. Images must satisfy it, so
+// a missing policy is an error. An empty path explicitly opts out of
+// verifying images.
+func newVerifier(path string, r *utils.Runner, reg *registry.Client) (*signature.Verifier, error) {
+	if path == "" {
+		glog.Warningf("No signature policy given; images will not be verified")
+		return nil, nil
 	}
-	changed := false
-	additions, deletions, err := parseTuningFile(tuningFilePath, cmdLinePath)
+	policy, err := signature.LoadPolicy(path)
 	if err != nil {
-		return changed, err
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s does not exist; use --signature-policy=\"\" to not verify images", path)
+		}
+		return nil, err
 	}
+	return signature.NewVerifier(policy, reg, r, pivot.RegistriesDir), nil
+}
 
-	// Execute additions
-	for _, toAdd := range additions {
-		if toAdd.Bare {
-			changed = true
-			utils.Run("rpm-ostree", "kargs", fmt.Sprintf("--append=%s", toAdd.Key))
-		} else {
-			// TODO: currently not supported
-		}
+// getRebootPolicy returns the reboot policy, in order of precedence, from
+// --reboot-policy, --reboot which reboots immediately, the reboot marker
+// markerFile, or the policy file configFile. Without any of them the system
+// is not rebooted. The marker may contain a policy, and otherwise reboots
+// immediately as it did before policies existed.
+func getRebootPolicy(markerFile, configFile string) (pivot.RebootPolicy, error) {
+	if rebootPolicy != "" {
+		return pivot.ParseRebootPolicy(rebootPolicy)
 	}
-	// Execute deletions
-	for _, toDelete := range deletions {
-		if toDelete.Bare {
-			changed = true
-			utils.Run("rpm-ostree", "kargs", fmt.Sprintf("--delete=%s", toDelete.Key))
-		} else {
-			// TODO: currently not supported
+	if reboot {
+		return pivot.RebootPolicy{Mode: pivot.RebootImmediate}, nil
+	}
+	if utils.FileExists(markerFile) {
+		policy, err := pivot.ReadRebootPolicy(markerFile)
+		if err != nil {
+			glog.Warningf("Rebooting immediately as the reboot marker is not a policy: %v", err)
 		}
+		if policy == nil {
+			return pivot.RebootPolicy{Mode: pivot.RebootImmediate}, nil
+		}
+		return *policy, nil
 	}
-	return changed, nil
-}
-
-// podmanRemove kills and removes a container
-func podmanRemove(cid string) {
-	utils.RunIgnoreErr("podman", "kill", cid)
-	utils.RunIgnoreErr("podman", "rm", "-f", cid)
-}
-
-// getDefaultDeployment uses rpm-ostree status --json to get the current deployment
-func getDefaultDeployment() types.RpmOstreeDeployment {
-	// use --status for now, we can switch to D-Bus if we need more info
-	var rosState types.RpmOstreeState
-	output := utils.RunGetOut("rpm-ostree", "status", "--json")
-	if err := json.Unmarshal([]byte(output), &rosState); err != nil {
-		glog.Fatalf("Failed to parse `rpm-ostree status --json` output: %v", err)
+	policy, err := pivot.ReadRebootPolicy(configFile)
+	if err != nil {
+		return pivot.RebootPolicy{}, err
 	}
-
-	// just make it a hard error if we somehow don't have any deployments
-	if len(rosState.Deployments) == 0 {
-		glog.Fatalf("Not currently booted in a deployment")
+	if policy != nil {
+		return *policy, nil
 	}
-
-	return rosState.Deployments[0]
+	return pivot.RebootPolicy{Mode: pivot.RebootNever}, nil
 }
 
-// getRefDigest parses a Docker/OCI image reference and returns
-// its digest, or an error if the string fails to parse as
-// a "canonical" image reference with a digest.
-func getRefDigest(ref string) (string, error) {
-	refParsed, err := imgref.ParseNamed(ref)
+// mustGetRebootPolicy returns the reboot policy of the system, exiting if
+// it is invalid. It is called before changing anything so that a bad
+// policy cannot leave a change without its reboot.
+func mustGetRebootPolicy() pivot.RebootPolicy {
+	policy, err := getRebootPolicy(pivot.RunPivotRebootFile, pivot.RebootPolicyFile)
 	if err != nil {
-		return "", fmt.Errorf("parsing reference: %q: %v", ref, err)
-	}
-	canon, ok := refParsed.(imgref.Canonical)
-	if !ok {
-		return "", fmt.Errorf("not canonical form: %q: %v", ref, err)
+		glog.Fatalf("Invalid reboot policy: %v", err)
 	}
-
-	return canon.Digest().String(), nil
+	return policy
 }
 
-// compareOSImageURL determines whether two images are the same, or have
-// matching digests.
-func compareOSImageURL(current, desired string) (bool, error) {
-	if current == desired {
-		return true, nil
+// rebootSystem reboots the machine as policy says
+func rebootSystem(r *utils.Runner, policy pivot.RebootPolicy) {
+	if err := pivot.Reboot(pivot.RebootOptions{Policy: policy, Runner: r}); err != nil {
+		glog.Fatalf("%v", err)
 	}
+}
 
-	currentDigest, err := getRefDigest(current)
-	if err != nil {
-		return false, fmt.Errorf("parsing current osImageURL: %v", err)
-	}
-	desiredDigest, err := getRefDigest(desired)
+// queuedRequests checks if there are requests queued in pivot.RequestsDir
+func queuedRequests() bool {
+	requests, err := pivot.ListRequests(pivot.RequestsDir)
 	if err != nil {
-		return false, fmt.Errorf("parsing desired osImageURL: %v", err)
-	}
-
-	if currentDigest == desiredDigest {
-		glog.Infof("Current and target osImageURL have matching digest %q", currentDigest)
-		return true, nil
+		glog.Fatalf("Failed to read requests: %v", err)
 	}
-
-	return false, nil
+	return len(requests) > 0
 }
 
-// pullAndRebase potentially rebases system if not already rebased.
-func pullAndRebase(container string) (imgid string, changed bool) {
-	defaultDeployment := getDefaultDeployment()
-
-	previousPivot := ""
-	if len(defaultDeployment.CustomOrigin) > 0 {
-		if strings.HasPrefix(defaultDeployment.CustomOrigin[0], "pivot://") {
-			previousPivot = defaultDeployment.CustomOrigin[0][len("pivot://"):]
-			glog.Infof("Previous pivot: %s", previousPivot)
+// executeRequests processes the requests queued in opts.RequestsDir,
+// rebooting at the end if any asked to after a change. The reboot happens
+// even if a later request failed, and the error is returned after it.
+func executeRequests(opts pivot.RequestOptions, reboot pivot.RebootOptions) error {
+	opts.Reboot = reboot.Policy.Mode != pivot.RebootNever
+	results, err := pivot.ProcessRequests(context.Background(), opts)
+	if opts.Pivot.DryRun {
+		for _, result := range results {
+			fmt.Fprintf(os.Stdout, "Request:            %s\n", result.Request)
+			if result.Result != nil {
+				printPlan(os.Stdout, *result.Result, result.Reboot)
+			}
 		}
+		return err
 	}
 
-	var authArgs []string
-	if utils.FileExists(kubeletAuthFile) {
-		authArgs = append(authArgs, "--authfile", kubeletAuthFile)
+	changed := false
+	rebootNeeded := false
+	for _, result := range results {
+		if result.Result != nil && result.Result.Changed {
+			changed = true
+			rebootNeeded = rebootNeeded || result.Reboot
+		}
 	}
-
-	// If we're passed a non-canonical image, resolve it to its sha256 now
-	isCanonicalForm := true
-	if _, err := getRefDigest(container); err != nil {
-		isCanonicalForm = false
-		// In non-canonical form, we pull unconditionally right now
-		args := []string{"pull", "-q"}
-		args = append(args, authArgs...)
-		args = append(args, container)
-		utils.RunExt(false, numRetriesNetCommands, "podman", args...)
-	} else {
-		targetMatched, err := compareOSImageURL(previousPivot, container)
+	if !changed {
 		if err != nil {
-			glog.Fatalf("%v", err)
+			return err
 		}
-		if targetMatched {
-			changed = false
-			return
+		glog.Info("Already at target pivot; exiting...")
+		if exit_77 {
+			os.Exit(77)
 		}
-
-		// Pull the image
-		args := []string{"pull", "-q"}
-		args = append(args, authArgs...)
-		args = append(args, container)
-		utils.RunExt(false, numRetriesNetCommands, "podman", args...)
-	}
-
-	inspectArgs := []string{"inspect", "--type=image"}
-	inspectArgs = append(inspectArgs, fmt.Sprintf("%s", container))
-	output := utils.RunExt(true, 1, "podman", inspectArgs...)
-	var imagedataArray []types.ImageInspection
-	json.Unmarshal([]byte(output), &imagedataArray)
-	imagedata := imagedataArray[0]
-	if !isCanonicalForm {
-		imgid = imagedata.RepoDigests[0]
-		glog.Infof("Resolved to: %s", imgid)
-	} else {
-		imgid = container
-	}
-
-	// Clean up any previous container which used the old name
-	podmanRemove(types.OldPivotName)
-
-	containerName := types.PivotNamePrefix + string(uuid.NewUUID())
-
-	// `podman mount` wants a container, so let's make create a dummy one, but not run it
-	cid := utils.RunGetOut("podman", "create", "--net=none", "--annotation=org.openshift.machineconfigoperator.pivot=true", "--name", containerName, imgid)
-
-	defer func() {
-		// Kill our dummy container
-		podmanRemove(containerName)
-	}()
-	// Use the container ID to find its mount point
-	mnt := utils.RunGetOut("podman", "mount", cid)
-	repo := fmt.Sprintf("%s/srv/repo", mnt)
-
-	// Now we need to figure out the commit to rebase to
-
-	// Commit label takes priority
-	ostree_csum, ok := imagedata.Labels["com.coreos.ostree-commit"]
-	if ok {
-		if ostree_version, ok := imagedata.Labels["version"]; ok {
-			glog.Infof("Pivoting to: %s (%s)", ostree_version, ostree_csum)
-		} else {
-			glog.Infof("Pivoting to: %s", ostree_csum)
+	} else if rebootNeeded {
+		// A request asking for a reboot overrides a policy of never
+		if reboot.Policy.Mode == pivot.RebootNever {
+			reboot.Policy.Mode = pivot.RebootImmediate
 		}
-	} else {
-		glog.Infof("No com.coreos.ostree-commit label found in metadata! Inspecting...")
-		refs := strings.Split(utils.RunGetOut("ostree", "refs", "--repo", repo), "\n")
-		if len(refs) == 1 {
-			glog.Infof("Using ref %s", refs[0])
-			ostree_csum = utils.RunGetOut("ostree", "rev-parse", "--repo", repo, refs[0])
-		} else if len(refs) > 1 {
-			glog.Fatalf("Multiple refs found in repo!")
-		} else {
-			// XXX: in the future, possibly scan the repo to find a unique .commit object
-			glog.Fatalf("No refs found in repo!")
+		if rebootErr := pivot.Reboot(reboot); rebootErr != nil {
+			if err == nil {
+				return rebootErr
+			}
+			glog.Errorf("%v", rebootErr)
 		}
 	}
-
-	// This will be what will be displayed in `rpm-ostree status` as the "origin spec"
-	customURL := fmt.Sprintf("pivot://%s", imgid)
-
-	// RPM-OSTree can now directly slurp from the mounted container!
-	// https://github.com/projectatomic/rpm-ostree/pull/1732
-	utils.Run("rpm-ostree", "rebase", "--experimental",
-		fmt.Sprintf("%s:%s", repo, ostree_csum),
-		"--custom-origin-url", customURL,
-		"--custom-origin-description", "Managed by pivot tool")
-
-	changed = true
-	return
+	return err
 }
 
-// Execute runs the command
+// Execute runs the command. Without an image pullspec, the one in
+// pivot.EtcPivotFile is used, or else the queued requests are processed.
 func Execute(cmd *cobra.Command, args []string) {
+	policy := mustGetRebootPolicy()
 	var fromFile bool
+	var fromQueue bool
 	var container string
 	if len(args) > 0 {
 		container = args[0]
 		fromFile = false
+	} else if !utils.FileExists(pivot.EtcPivotFile) && queuedRequests() {
+		glog.Infof("Processing requests from %s", pivot.RequestsDir)
+		fromQueue = true
 	} else {
-		glog.Infof("Using image pullspec from %s", etcPivotFile)
-		data, err := ioutil.ReadFile(etcPivotFile)
+		glog.Infof("Using image pullspec from %s", pivot.EtcPivotFile)
+		data, err := ioutil.ReadFile(pivot.EtcPivotFile)
 		if err != nil {
-			glog.Fatalf("Failed to read from %s: %v", etcPivotFile, err)
+			glog.Fatalf("Failed to read from %s: %v", pivot.EtcPivotFile, err)
 		}
 		container = strings.TrimSpace(string(data))
 		fromFile = true
 	}
 
-	imgid, changed := pullAndRebase(container)
-
-	// Delete the file now that we successfully rebased
-	if fromFile {
-		if err := os.Remove(etcPivotFile); err != nil {
-			if !os.IsNotExist(err) {
-				glog.Fatalf("Failed to delete %s: %v", etcPivotFile, err)
-			}
-		}
+	if ostreeRef != "" && ostreeCommit != "" {
+		glog.Fatalf("--ref and --commit cannot be used together")
 	}
 
-	// By default, delete the image.
-	if !keep {
-		// Related: https://github.com/containers/libpod/issues/2234
-		utils.RunIgnoreErr("podman", "rmi", imgid)
+	r := utils.NewRunner(nil)
+	reg := registry.NewClient(pivot.KubeletAuthFile)
+	reg.IgnorePlatform = ignorePlatform
+	provider, err := newImageProvider(imageProvider, r, reg)
+	if err != nil {
+		glog.Fatalf("%v", err)
 	}
-
-	// Check to see if we need to tune kernel arguments
-	tuningChanged, err := updateTuningArgs(kernelTuningFile, cmdLineFile)
+	verifier, err := newVerifier(signaturePolicy, r, reg)
 	if err != nil {
-		glog.Infof("unable to parse tuning file %s: %s", kernelTuningFile, err)
+		glog.Fatalf("Failed to load signature policy: %v", err)
 	}
-	// If tuning changes but the oscontainer didn't we still denote we changed
-	// for the reboot
-	if tuningChanged {
-		changed = true
-		if err != nil {
-			glog.Infof(`Unable to remove kernel tuning file %s: "%s"`, kernelTuningFile, err)
+	opts := pivot.Options{
+		Image:          container,
+		Keep:           keep,
+		DryRun:         dryRun,
+		Runner:         r,
+		Registry:       reg,
+		Provider:       provider,
+		Verifier:       verifier,
+		Ref:            ostreeRef,
+		Commit:         ostreeCommit,
+		IgnorePlatform: ignorePlatform,
+		StrictTuning:   strict,
+		HistoryFile:    pivot.HistoryFile,
+		TargetFile:     pivot.TargetFile,
+	}
+	if fromQueue {
+		if err := executeRequests(pivot.RequestOptions{Pivot: opts}, pivot.RebootOptions{Policy: policy, Runner: r}); err != nil {
+			glog.Fatalf("%v", err)
 		}
+		return
+	}
+	res, err := pivot.Pivot(context.Background(), opts)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
 
+	if dryRun {
+		printPlan(os.Stdout, res, policy.Mode != pivot.RebootNever)
+		return
 	}
 
-	if !changed {
+	// Delete the file now that we successfully rebased
+	if fromFile {
+		if err := os.Remove(pivot.EtcPivotFile); err != nil {
+			if !os.IsNotExist(err) {
+				glog.Fatalf("Failed to delete %s: %v", pivot.EtcPivotFile, err)
+			}
+		}
+	}
+
+	if !res.Changed {
 		glog.Info("Already at target pivot; exiting...")
 		if exit_77 {
 			os.Exit(77)
 		}
-	} else if reboot || utils.FileExists(runPivotRebootFile) {
-		// Reboot the machine if asked to do so
-		utils.Run("systemctl", "reboot")
+	} else {
+		rebootSystem(r, policy)
 	}
 }
@@ -1,142 +1,286 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/openshift/pivot/pkg/pivot"
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
 )
 
-func mustCompareOSImageURL(t *testing.T, refA, refB string) bool {
-	m, err := compareOSImageURL(refA, refB)
-	if err != nil {
-		t.Fatalf("%v", err)
+func TestPrintPlan(t *testing.T) {
+	var out bytes.Buffer
+	printPlan(&out, pivot.Result{
+		ImageID:         "registry.example.com/os@sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f",
+		Rebased:         true,
+		Changed:         true,
+		RebootRequired:  true,
+		KernelArgsAdded: []types.TuneArgument{{Key: "nosmt", Bare: true}},
+	}, true)
+	for _, expected := range []string{
+		"OSTree commit:      (unknown until the image is pulled)\n",
+		"Rebase:             yes\n",
+		"Kernel args to add: nosmt\n",
+		"Kernel args to del: (none)\n",
+		"Reboot:             yes\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, out.String())
+		}
 	}
-	return m
 }
 
-func TestCompareOSImageURL(t *testing.T) {
-	refA := "registry.example.com/foo/bar@sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f"
-	refB := "registry.example.com/foo/baz@sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f"
-	refC := "registry.example.com/foo/bar@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff"
-	if !mustCompareOSImageURL(t, refA, refA) {
-		t.Fatalf("Expected refA ident")
+func TestPrintStatus(t *testing.T) {
+	status := pivot.Status{
+		Booted: &pivot.DeploymentStatus{
+			Checksum:   "abcd",
+			Version:    "42.1",
+			PivotImage: "registry.example.com/os@sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f",
+		},
+		PendingPullspec:        "registry.example.com/os:latest",
+		PendingKernelArgsAdded: []types.TuneArgument{{Key: "nosmt", Bare: true}},
+		RebootMarker:           true,
+	}
+
+	var out bytes.Buffer
+	if err := printStatus(&out, status, "human"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
 	}
-	if !mustCompareOSImageURL(t, refA, refB) {
-		t.Fatalf("Expected refA = refB")
+	for _, expected := range []string{
+		"Booted:           42.1 abcd\n",
+		"Pending:          (none)\n",
+		"Pending pullspec: registry.example.com/os:latest\n",
+		"Kernel args add:  nosmt\n",
+		"Reboot marker:    yes\n",
+		"Reboot required:  no\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, out.String())
+		}
 	}
-	if mustCompareOSImageURL(t, refA, refC) {
-		t.Fatalf("Expected refA != refC")
+
+	out.Reset()
+	if err := printStatus(&out, status, "json"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
 	}
-	m, err := compareOSImageURL(refA, "registry.example.com/foo/bar")
-	if m || err == nil {
-		t.Fatalf("Expected err")
+	var decoded pivot.Status
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	if decoded.Booted.Checksum != "abcd" || !decoded.RebootMarker {
+		t.Fatalf("Expected the status to round trip, got %+v", decoded)
 	}
-}
 
-// writeTestFile writes out a file to use in the test
-func writeTestFile(content []byte) (filePath string, err error) {
-	tmpfile, err := ioutil.TempFile("", "testFile")
-	if err != nil {
-		return "", err
+	if err := printStatus(&out, status, "yaml"); err == nil {
+		t.Fatalf("Expected an error for an unknown format")
 	}
-	filePath = tmpfile.Name()
-	if _, err := tmpfile.Write(content); err != nil {
-		return filePath, err
+}
+
+func TestNewImageProvider(t *testing.T) {
+	for _, name := range []string{"", "podman", "registry"} {
+		if provider, err := newImageProvider(name, nil, nil); err != nil || provider == nil {
+			t.Errorf("Expected a provider for %q, got %v", name, err)
+		}
 	}
-	if err := tmpfile.Close(); err != nil {
-		return filePath, err
+	if _, err := newImageProvider("docker", nil, nil); err == nil {
+		t.Errorf("Expected an error for an unknown provider")
 	}
-	return filePath, nil
 }
 
-func TestParseTuningFile(t *testing.T) {
-	cmdLineFileMock, err := writeTestFile([]byte(
-		"BOOT_IMAGE=/a/vmlinuz.x86_64 resume=/dev/mapper/swap rhgb quiet root=/a/b/c/root ostree=/ostree/boot.0/a/0"))
-	defer os.Remove(cmdLineFileMock)
+func TestPrintHistory(t *testing.T) {
+	started := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := []pivot.HistoryEntry{
+		{
+			Pullspec:           "registry.example.com/os:latest",
+			ImageID:            "registry.example.com/os@sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f",
+			Commit:             "0123456789abcdef",
+			Version:            "42.1",
+			KernelArgsAdded:    []types.TuneArgument{{Key: "nosmt", Bare: true}},
+			KernelArgsReplaced: []types.TuneReplacement{{Key: "hugepages", OldValue: "16", Value: "32"}},
+			Started:            started,
+			Finished:           started.Add(90 * time.Second),
+			Outcome:            pivot.HistoryChanged,
+		},
+		{
+			Pullspec: "registry.example.com/os:next",
+			Started:  started.Add(time.Hour),
+			Finished: started.Add(time.Hour + time.Second),
+			Outcome:  pivot.HistoryFailed,
+			Error:    "unable to pull image",
+		},
+	}
 
-	// Test with addition/deletion and verify white list
-	testFilePath, err := writeTestFile([]byte("ADD nosmt\nADD aaaa\nDELETE nosmt\nDELETE nope"))
-	defer os.Remove(testFilePath)
-	if err != nil {
-		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	var out bytes.Buffer
+	if err := printHistory(&out, entries, "table"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
 	}
-	add, delete, err := parseTuningFile(testFilePath, cmdLineFileMock)
-	if err != nil {
-		t.Fatalf(`Expected no error, got %s`, err)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "STARTED") {
+		t.Fatalf("Expected a header and 2 rows, got:\n%s", out.String())
 	}
-	if len(add) != 1 {
-		t.Fatalf("Expected 1 addition, got %v", len(add))
+	for _, expected := range []string{"2019-05-01T12:00:00Z", "1m30s", "changed", "42.1", "0123456789ab ", "+nosmt hugepages=16->32"} {
+		if !strings.Contains(lines[1], expected) {
+			t.Errorf("Expected %q in %q", expected, lines[1])
+		}
 	}
-
-	if len(delete) != 0 {
-		t.Fatalf("Expected 0 deletion, got %v", len(delete))
+	for _, expected := range []string{"failed", "registry.example.com/os:next", "unable to pull image"} {
+		if !strings.Contains(lines[2], expected) {
+			t.Errorf("Expected %q in %q", expected, lines[2])
+		}
 	}
 
-	deleteCmdLineFileMockWith, err := writeTestFile([]byte(
-		"BOOT_IMAGE=/a/vmlinuz.x86_64 nosmt resume=/dev/mapper/swap rhgb quiet root=/a/b/c/root ostree=/ostree/boot.0/a/0"))
-	defer os.Remove(deleteCmdLineFileMockWith)
-
-	// Test with addition/deletion and verify white list
-	testFilePath, err = writeTestFile([]byte("ADD nosmt\nADD aaaa\nDELETE nosmt\nDELETE nope"))
-	defer os.Remove(testFilePath)
-	if err != nil {
-		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	out.Reset()
+	if err := printHistory(&out, entries, "json"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
 	}
-	add, delete, err = parseTuningFile(testFilePath, deleteCmdLineFileMockWith)
-	if err != nil {
-		t.Fatalf(`Expected no error, got %s`, err)
+	var decoded []pivot.HistoryEntry
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
 	}
-	if len(add) != 0 {
-		t.Fatalf("Expected 1 addition, got %v", len(add))
+	if len(decoded) != 2 || decoded[1].Error != "unable to pull image" {
+		t.Fatalf("Expected the history to round trip, got %+v", decoded)
 	}
 
-	if len(delete) != 1 {
-		t.Fatalf("Expected 1 deletion, got %v", len(delete))
+	if err := printHistory(&out, entries, "yaml"); err == nil {
+		t.Fatalf("Expected an error for an unknown format")
 	}
+}
 
-	// Test with no changes
-	testFilePath, err = writeTestFile([]byte(""))
-	defer os.Remove(testFilePath)
+func TestGetRebootPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reboot")
 	if err != nil {
-		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
-	}
-	add, delete, err = parseTuningFile(testFilePath, cmdLineFileMock)
-	if err != nil {
-		t.Fatalf(`Expected no error, got %s`, err)
+		t.Fatalf("%v", err)
 	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "reboot-needed")
+	config := filepath.Join(dir, "reboot-policy")
+	defer func() { rebootPolicy, reboot = "", false }()
 
-	if len(add) != 0 {
-		t.Fatalf("Expected 0 addition, got %v", len(add))
+	for _, test := range []struct {
+		flagPolicy string
+		flagReboot bool
+		marker     string // Not created if empty
+		config     string // Not created if empty
+		expected   string
+	}{
+		{"", false, "", "", "never"},
+		{"", false, "", "delayed:30", "delayed:30"},
+		{"", false, "\n", "delayed:30", "immediate"},
+		{"", false, "delayed:10", "never", "delayed:10"},
+		{"", false, "1", "", "immediate"},
+		{"", true, "", "never", "immediate"},
+		{"marker", true, "delayed:10", "never", "marker"},
+	} {
+		os.Remove(marker)
+		os.Remove(config)
+		if test.marker != "" {
+			ioutil.WriteFile(marker, []byte(test.marker), 0644)
+		}
+		if test.config != "" {
+			ioutil.WriteFile(config, []byte(test.config), 0644)
+		}
+		rebootPolicy, reboot = test.flagPolicy, test.flagReboot
+		policy, err := getRebootPolicy(marker, config)
+		if err != nil || policy.String() != test.expected {
+			t.Errorf("Expected %s for %+v, got %s %v", test.expected, test, policy, err)
+		}
 	}
 
-	if len(delete) != 0 {
-		t.Fatalf("Expected 0 deletion, got %v", len(add))
+	// Invalid policies given explicitly are errors
+	os.Remove(marker)
+	rebootPolicy, reboot = "delayd:30", false
+	if _, err := getRebootPolicy(marker, config); err == nil {
+		t.Errorf("Expected an invalid --reboot-policy to be an error")
+	}
+	rebootPolicy = ""
+	ioutil.WriteFile(config, []byte("sometimes"), 0644)
+	if _, err := getRebootPolicy(marker, config); err == nil {
+		t.Errorf("Expected an invalid policy file to be an error")
 	}
 }
 
-func TestIsArgInUse(t *testing.T) {
-	testFilePath, err := writeTestFile([]byte(
-		"BOOT_IMAGE=/a/vmlinuz.x86_64 resume=/dev/mapper/swap rhgb quiet root=/a/b/c/root ostree=/ostree/boot.0/a/0"))
+func TestNewVerifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
 	if err != nil {
-		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+		t.Fatalf("%v", err)
 	}
-	defer os.Remove(testFilePath)
+	defer os.RemoveAll(dir)
 
-	// Should be present
-	available, err := isArgInUse("quiet", testFilePath)
-	if err != nil {
-		t.Fatalf(`Expected no error, got %s`, err)
+	// Images are only left unverified when asked to
+	if verifier, err := newVerifier("", nil, nil); err != nil || verifier != nil {
+		t.Fatalf("Expected no verifier, got %v %v", verifier, err)
+	}
+	if _, err := newVerifier(filepath.Join(dir, "policy.json"), nil, nil); err == nil {
+		t.Fatalf("Expected a missing policy to be an error")
 	}
-	if available != true {
-		t.Fatalf("Expected true, got false")
+	path := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(path, []byte(`{"default": [{"type": "reject"}]}`), 0644); err != nil {
+		t.Fatalf("%v", err)
 	}
+	if verifier, err := newVerifier(path, nil, nil); err != nil || verifier == nil {
+		t.Fatalf("Expected a verifier, got %v %v", verifier, err)
+	}
+}
 
-	// Should not be present
-	available, err = isArgInUse("idonotexist", testFilePath)
+func TestExecuteRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "requests")
 	if err != nil {
-		t.Fatalf(`Expected no error, got %s`, err)
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	requestsDir := filepath.Join(dir, "requests.d")
+	if err := os.MkdirAll(requestsDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for name, content := range map[string]string{
+		"requests.d/10-kargs.json": `{"kernelArgs": ["ADD nosmt"], "reboot": true}`,
+		"requests.d/20-typo.json":  `{"imgae": "registry.example.com/os:latest"}`,
+		"cmdline":                  "BOOT_IMAGE=/vmlinuz quiet",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	allowlist, err := pivot.LoadAllowlist(filepath.Join(dir, "allowlist.d"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The first request reboots even though the second fails
+	fake := utils.NewFakeExecutor()
+	r := utils.NewRunner(fake)
+	r.RetryDelay = 0
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "abcd", "booted": true}]}`})
+	err = executeRequests(pivot.RequestOptions{
+		Pivot: pivot.Options{
+			TuningFile:          filepath.Join(dir, "missing"),
+			KernelArgsFile:      filepath.Join(dir, "missing.json"),
+			KernelArgsStateFile: filepath.Join(dir, "kernel-args-owned.json"),
+			TuningStateFile:     filepath.Join(dir, "kernel-args-applied.json"),
+			CmdLineFile:         filepath.Join(dir, "cmdline"),
+			HooksDir:            filepath.Join(dir, "hooks.d"),
+			ProfileDirs:         []string{filepath.Join(dir, "profiles")},
+			Allowlist:           allowlist,
+			Runner:              r,
+		},
+		RequestsDir: requestsDir,
+		ResultsDir:  filepath.Join(dir, "results"),
+	}, pivot.RebootOptions{
+		Policy:   pivot.RebootPolicy{Mode: pivot.RebootNever},
+		Runner:   r,
+		HooksDir: filepath.Join(dir, "hooks.d"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "20-typo.json") {
+		t.Fatalf("Expected the second request to fail, got %v", err)
 	}
-	if available != false {
-		t.Fatalf("Expected false, got true")
+	if !fake.Called("rpm-ostree kargs --append=nosmt") || !fake.Called("systemctl reboot") {
+		t.Fatalf("Expected the first request to change the kernel arguments and reboot, got %v", fake.Calls)
 	}
 }
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/pivot"
+	"github.com/spf13/cobra"
+)
+
+// flag storage
+var output string
+
+// StatusCmd houses the cobra config for the status command
+var StatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the pivot state of the system",
+	Args:  cobra.NoArgs,
+	Run:   ExecuteStatus,
+}
+
+// init executes upon import
+func init() {
+	StatusCmd.Flags().StringVarP(&output, "output", "o", "human", "Output format: human or json")
+	RootCmd.AddCommand(StatusCmd)
+}
+
+// printDeployment writes out a single deployment for human consumption
+func printDeployment(w io.Writer, title string, deployment *pivot.DeploymentStatus) {
+	if deployment == nil {
+		fmt.Fprintf(w, "%-17s (none)\n", title+":")
+		return
+	}
+	version := deployment.Version
+	if version == "" {
+		version = "(no version)"
+	}
+	image := deployment.PivotImage
+	if image == "" {
+		image = "(not managed by pivot)"
+	}
+	fmt.Fprintf(w, "%-17s %s %s\n", title+":", version, deployment.Checksum)
+	fmt.Fprintf(w, "%-17s %s\n", "  Image:", image)
+	if deployment.Staged {
+		fmt.Fprintf(w, "%-17s yes\n", "  Staged:")
+	}
+}
+
+// printStatus writes out the status in the requested format
+func printStatus(w io.Writer, status pivot.Status, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+	case "human":
+		yesNo := map[bool]string{true: "yes", false: "no"}
+		pullspec := status.PendingPullspec
+		if pullspec == "" {
+			pullspec = "(none)"
+		}
+		printDeployment(w, "Booted", status.Booted)
+		printDeployment(w, "Pending", status.Pending)
+		fmt.Fprintf(w, "%-17s %s\n", "Pending pullspec:", pullspec)
+		fmt.Fprintf(w, "%-17s %s\n", "Kernel args add:", formatArgs(status.PendingKernelArgsAdded))
+		fmt.Fprintf(w, "%-17s %s\n", "Kernel args del:", formatArgs(status.PendingKernelArgsDeleted))
+		fmt.Fprintf(w, "%-17s %s\n", "Kernel args set:", formatReplacements(status.PendingKernelArgsReplaced))
+		fmt.Fprintf(w, "%-17s %s\n", "Tuning applied:", formatAppliedTuning(status.AppliedKernelTuning))
+		fmt.Fprintf(w, "%-17s %s\n", "Reboot marker:", yesNo[status.RebootMarker])
+		fmt.Fprintf(w, "%-17s %s\n", "Reboot required:", yesNo[status.RebootRequired])
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	return nil
+}
+
+// ExecuteStatus runs the status command
+func ExecuteStatus(cmd *cobra.Command, args []string) {
+	status, err := pivot.GetStatus(pivot.Options{})
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	if err := printStatus(os.Stdout, status, output); err != nil {
+		glog.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/pivot"
+	"github.com/spf13/cobra"
+)
+
+// ValidateKargsCmd houses the cobra config for the validate-kargs command
+var ValidateKargsCmd = &cobra.Command{
+	Use:   "validate-kargs FILE",
+	Short: "Checks a kernel tuning file against the syntax and allowlist",
+	Args:  cobra.ExactArgs(1),
+	Run:   ExecuteValidateKargs,
+}
+
+// init executes upon import
+func init() {
+	RootCmd.AddCommand(ValidateKargsCmd)
+}
+
+// printTuningProblems writes out each problem in err on its own line
+func printTuningProblems(w io.Writer, err *pivot.TuningFileError) {
+	for _, p := range err.Problems {
+		fmt.Fprintf(w, "%s:%d: %s: %s\n", err.File, p.Line, p.Reason, p.Text)
+	}
+}
+
+// ExecuteValidateKargs runs the validate-kargs command
+func ExecuteValidateKargs(cmd *cobra.Command, args []string) {
+	allowlist, err := pivot.LoadAllowlist(pivot.LibAllowlistDir, pivot.EtcAllowlistDir)
+	if err != nil {
+		glog.Fatalf("Failed to load the kernel argument allowlist: %v", err)
+	}
+	err = pivot.ValidateTuningFile(args[0], allowlist, pivot.LibProfilesDir, pivot.EtcProfilesDir)
+	if tuningErr, ok := err.(*pivot.TuningFileError); ok {
+		printTuningProblems(os.Stdout, tuningErr)
+		glog.Fatalf("%s has %d invalid lines", args[0], len(tuningErr.Problems))
+	}
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+}
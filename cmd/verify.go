@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/pivot"
+	"github.com/openshift/pivot/utils"
+	"github.com/spf13/cobra"
+)
+
+// flag storage
+var maxRollbacks int
+
+// VerifyCmd houses the cobra config for the verify command
+var VerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Checks the system booted the pivot target and is healthy, rolling back if not",
+	Args:  cobra.NoArgs,
+	Run:   ExecuteVerify,
+}
+
+// init executes upon import
+func init() {
+	VerifyCmd.Flags().IntVar(&maxRollbacks, "max-rollbacks", 1, "Rollbacks allowed after a pivot before giving up")
+	RootCmd.AddCommand(VerifyCmd)
+}
+
+// ExecuteVerify runs the verify command
+func ExecuteVerify(cmd *cobra.Command, args []string) {
+	r := utils.NewRunner(nil)
+	res, err := pivot.Verify(pivot.VerifyOptions{
+		MaxRollbacks: maxRollbacks,
+		Runner:       r,
+	})
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	if res.RolledBack {
+		glog.Infof("Rolled back from %s to %s; rebooting", res.Rollback.From.Checksum, res.Rollback.To.Checksum)
+		rebootSystem(r, pivot.RebootPolicy{Mode: pivot.RebootImmediate})
+	}
+}
@@ -13,14 +13,15 @@ import (
 var commitHash string
 var version string
 
-// showHeader generates and prints the program header line
+// showHeader generates and prints the program header line. It goes to stderr
+// so machine readable output on stdout is not disturbed.
 func showHeader() {
 	header := fmt.Sprintf("pivot version %s", version)
 	// If we have a commit hash then add it to the program header
 	if commitHash != "" {
 		header = fmt.Sprintf("%s (%s)", header, commitHash)
 	}
-	fmt.Println(header)
+	fmt.Fprintln(os.Stderr, header)
 }
 
 // main is the entry point for the command
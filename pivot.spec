@@ -22,7 +22,7 @@ deployment to another with minimal effort.
 %prep
 %autosetup -n %{name}-%{version}
 mkdir -p src/github.com/openshift/%{name}/
-cp -rf cmd  Gopkg.lock  Gopkg.toml  LICENSE  main.go  Makefile  pivot.spec  README.md  types  utils vendor VERSION systemd src/github.com/openshift/%{name}
+cp -rf cmd  Gopkg.lock  Gopkg.toml  LICENSE  main.go  Makefile  pivot.spec  README.md  pkg  profiles  types  utils vendor VERSION systemd src/github.com/openshift/%{name}
 
 %build
 export GOPATH=`pwd`
@@ -38,6 +38,11 @@ make install DESTDIR=%{buildroot}
 %doc README.md
 %{_bindir}/%{name}
 %{_prefix}/lib/systemd/system/pivot.*
+%{_prefix}/lib/systemd/system/pivot-verify.*
+%dir %{_prefix}/lib/pivot
+%dir %{_prefix}/lib/pivot/kernel-args-allowlist.d
+%dir %{_prefix}/lib/pivot/profiles
+%{_prefix}/lib/pivot/profiles/*.conf
 
 %changelog
 * Thu Apr 25 2019 Colin Walters <walters@redhat.com> - 0.0.5-1
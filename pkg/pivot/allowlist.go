@@ -0,0 +1,208 @@
+package pivot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/openshift/pivot/types"
+)
+
+// defaultAllowlistName is the name of the built in allowlist drop-in. A
+// drop-in of the same name replaces it, an empty one removes it.
+const defaultAllowlistName = "00-default.conf"
+
+// defaultAllowlist are the kernel arguments allowed unless overridden
+const defaultAllowlist = `# Kernel arguments pivot may change by default
+nosmt
+isolcpus=((domain|nohz|managed_irq),)*(\d+(-\d+)?)(,\d+(-\d+)?)*
+nohz_full=(\d+(-\d+)?)(,\d+(-\d+)?)*
+rcu_nocbs=(\d+(-\d+)?)(,\d+(-\d+)?)*
+hugepagesz=\d+[KMG]?
+default_hugepagesz=\d+[KMG]?
+hugepages=\d+
+mitigations=off|auto|auto,nosmt
+`
+
+// deniedKernelArgs are the keys of arguments pivot must never change,
+// whatever the allowlist says, as the system would not boot
+var deniedKernelArgs = []string{"root", "ostree", "BOOT_IMAGE"}
+
+// allowRule matches kernel arguments by key and value
+type allowRule struct {
+	key    string         // The key, or key prefix
+	prefix bool           // If key is a prefix
+	value  *regexp.Regexp // The pattern values must match, nil if the argument is bare
+}
+
+// matchesKey checks if the rule applies to the key
+func (r allowRule) matchesKey(key string) bool {
+	if r.prefix {
+		return strings.HasPrefix(key, r.key)
+	}
+	return key == r.key
+}
+
+// matches checks if the rule applies to the argument
+func (r allowRule) matches(arg types.TuneArgument) bool {
+	if !r.matchesKey(arg.Key) {
+		return false
+	}
+	if r.value == nil {
+		return arg.Bare
+	}
+	return !arg.Bare && r.value.MatchString(arg.Value)
+}
+
+// Allowlist decides which kernel arguments pivot may change
+type Allowlist struct {
+	allowed []allowRule
+	denied  []allowRule
+}
+
+// parseAllowRule parses a line of an allowlist drop-in. The forms are:
+//
+//	key            the bare argument key
+//	key=pattern    key with a value matching the regular expression pattern
+//	key*[=pattern] as above for keys beginning with key
+//	!key[*]        keys which are never allowed, with any value
+func parseAllowRule(line string) (allowRule, bool, error) {
+	var rule allowRule
+	deny := strings.HasPrefix(line, "!")
+	line = strings.TrimPrefix(line, "!")
+	parts := strings.SplitN(line, "=", 2)
+	rule.key = parts[0]
+	if strings.HasSuffix(rule.key, "*") {
+		rule.key = strings.TrimSuffix(rule.key, "*")
+		rule.prefix = true
+	}
+	if rule.key == "" || strings.ContainsAny(rule.key, " \t*") {
+		return rule, deny, fmt.Errorf("invalid key %q", parts[0])
+	}
+	if deny {
+		if len(parts) == 2 {
+			return rule, deny, fmt.Errorf("denied arguments cannot have a value pattern")
+		}
+		return rule, deny, nil
+	}
+	if len(parts) == 2 {
+		pattern, err := regexp.Compile("^(" + parts[1] + ")$")
+		if err != nil {
+			return rule, deny, err
+		}
+		rule.value = pattern
+	}
+	return rule, deny, nil
+}
+
+// parse adds the rules read from r, named name in errors
+func (a *Allowlist) parse(name string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, deny, err := parseAllowRule(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNumber, err)
+		}
+		if deny {
+			a.denied = append(a.denied, rule)
+		} else {
+			a.allowed = append(a.allowed, rule)
+		}
+	}
+	return scanner.Err()
+}
+
+// defaultKernelArgsAllowlist returns the Allowlist used when there are no drop-ins
+func defaultKernelArgsAllowlist() *Allowlist {
+	a, err := LoadAllowlist()
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// LoadAllowlist reads the *.conf drop-ins in dirs, which default to
+// LibAllowlistDir and EtcAllowlistDir. Drop-ins in later directories replace
+// those of the same name in earlier ones, and all are read in name order.
+// A missing directory is not an error.
+func LoadAllowlist(dirs ...string) (*Allowlist, error) {
+	a := &Allowlist{}
+	for _, key := range deniedKernelArgs {
+		a.denied = append(a.denied, allowRule{key: key})
+	}
+
+	files := map[string]string{defaultAllowlistName: ""}
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".conf") {
+				files[entry.Name()] = filepath.Join(dir, entry.Name())
+			}
+		}
+	}
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := files[name]
+		if path == "" {
+			if err := a.parse(name, strings.NewReader(defaultAllowlist)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = a.parse(path, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Denied checks if pivot must never change the argument
+func (a *Allowlist) Denied(arg types.TuneArgument) bool {
+	for _, rule := range a.denied {
+		if rule.matchesKey(arg.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed checks if the argument may be changed by pivot. The key must be
+// allowed and not denied, and the value must match the pattern for the key.
+func (a *Allowlist) Allowed(arg types.TuneArgument) bool {
+	if a.Denied(arg) {
+		return false
+	}
+	for _, rule := range a.allowed {
+		if rule.matches(arg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift/pivot/types"
+)
+
+func TestLoadAllowlist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allowlist")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "lib")
+	etc := filepath.Join(dir, "etc")
+	for path, content := range map[string]string{
+		"lib/10-rt.conf":    "# Real time tuning\nrcu_nocb*=\\d+\nskew_tick=1\n",
+		"lib/20-root.conf":  "root=.*\n",
+		"etc/10-rt.conf":    "rcu_nocb*=\\d+\n!nohz_full\n",
+		"etc/README":        "not a drop-in",
+		"etc/30-quiet.conf": "quiet\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	allowlist, err := LoadAllowlist(lib, etc, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for arg, expected := range map[string]bool{
+		"nosmt":                 true,  // built in default
+		"isolcpus=2-7":          true,  // built in default
+		"isolcpus=all":          false, // value does not match
+		"rcu_nocbs=3":           true,  // prefix
+		"rcu_nocb_poll=1":       true,  // prefix
+		"rcu_nocbs":             false, // value required
+		"skew_tick=1":           false, // overridden in etc
+		"nohz_full=2-7":         false, // denied in etc
+		"root=/dev/sda1":        false, // always denied
+		"ostree=/ostree/boot.0": false, // always denied
+		"quiet":                 true,
+	} {
+		if allowlist.Allowed(types.ParseTuneArgument(arg)) != expected {
+			t.Errorf("Expected %s allowed to be %v", arg, expected)
+		}
+	}
+	if !allowlist.Denied(types.ParseTuneArgument("BOOT_IMAGE=/vmlinuz")) {
+		t.Errorf("Expected BOOT_IMAGE to be denied")
+	}
+
+	// An empty drop-in replaces the built in defaults
+	if err := ioutil.WriteFile(filepath.Join(etc, defaultAllowlistName), nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if allowlist, err = LoadAllowlist(lib, etc); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if allowlist.Allowed(types.ParseTuneArgument("nosmt")) {
+		t.Errorf("Expected nosmt to no longer be allowed")
+	}
+
+	// Errors report the file and line
+	if err := ioutil.WriteFile(filepath.Join(etc, "40-bad.conf"), []byte("# bad\nisolcpus=(\n"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err = LoadAllowlist(lib, etc); err == nil || !strings.Contains(err.Error(), "40-bad.conf:2:") {
+		t.Fatalf("Expected an error for 40-bad.conf line 2, got %v", err)
+	}
+}
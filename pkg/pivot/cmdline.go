@@ -0,0 +1,149 @@
+package pivot
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+// CmdLine is a kernel command line split into arguments in order. Keys
+// such as console may be repeated.
+type CmdLine []types.TuneArgument
+
+// splitCmdLine splits a kernel command line on whitespace outside of double
+// quotes, keeping the quotes
+func splitCmdLine(cmdline string) []string {
+	args := []string{}
+	var current strings.Builder
+	inQuote := false
+	for _, c := range cmdline {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+			current.WriteRune(c)
+		case !inQuote && (c == ' ' || c == '\t' || c == '\n'):
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// ParseCmdLine parses a kernel command line as the kernel does
+func ParseCmdLine(cmdline string) CmdLine {
+	parsed := CmdLine{}
+	for _, arg := range splitCmdLine(cmdline) {
+		parsed = append(parsed, types.ParseTuneArgument(arg))
+	}
+	return parsed
+}
+
+// ReadCmdLine reads and parses the kernel command line at path
+func ReadCmdLine(path string) (CmdLine, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCmdLine(string(content)), nil
+}
+
+// Contains checks if the command line has the argument. A bare argument
+// only matches a bare argument, and an argument with a value only matches
+// the same key with the same value.
+func (c CmdLine) Contains(arg types.TuneArgument) bool {
+	for _, a := range c {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// Values returns the values of every occurrence of key, in order
+func (c CmdLine) Values(key string) []string {
+	values := []string{}
+	for _, a := range c {
+		if a.Key == key && !a.Bare {
+			values = append(values, a.Value)
+		}
+	}
+	return values
+}
+
+// readBLSOptions returns the kernel arguments of the default boot loader
+// entry in entriesDir, the one with the highest version
+func readBLSOptions(entriesDir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(entriesDir, "*.conf"))
+	if err != nil {
+		return "", err
+	}
+	found := false
+	highest := -1
+	options := ""
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		version := 0
+		entryOptions := ""
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			parts := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch parts[0] {
+			case "version":
+				version, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+			case "options":
+				entryOptions = strings.TrimSpace(parts[1])
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		if version > highest {
+			highest = version
+			options = entryOptions
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("no boot loader entries found in %s", entriesDir)
+	}
+	return options, nil
+}
+
+// nextBootCmdLine returns the kernel arguments of the deployment which boots
+// next, which may be a pending deployment rather than the booted one. They
+// are read with rpm-ostree, or from the boot loader entries in entriesDir
+// if that fails.
+func nextBootCmdLine(r *utils.Runner, entriesDir string) (CmdLine, error) {
+	out, err := r.RunGetOut("rpm-ostree", "kargs")
+	if err == nil {
+		return ParseCmdLine(out), nil
+	}
+	glog.Warningf("Unable to read kernel arguments with rpm-ostree, using %s: %v", entriesDir, err)
+	options, blsErr := readBLSOptions(entriesDir)
+	if blsErr != nil {
+		return nil, fmt.Errorf("reading kernel arguments: %v; %v", err, blsErr)
+	}
+	return ParseCmdLine(options), nil
+}
@@ -0,0 +1,80 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+func TestParseCmdLine(t *testing.T) {
+	cmdline := ParseCmdLine(`BOOT_IMAGE=/vmlinuz nosmt=force  console=tty0 console=ttyS0,115200n8 dyndbg="file drivers/* +p" "acpi=off noapic" quiet` + "\n")
+	expected := CmdLine{
+		{Key: "BOOT_IMAGE", Value: "/vmlinuz"},
+		{Key: "nosmt", Value: "force"},
+		{Key: "console", Value: "tty0"},
+		{Key: "console", Value: "ttyS0,115200n8"},
+		{Key: "dyndbg", Value: "file drivers/* +p"},
+		{Key: "acpi", Value: "off noapic"},
+		{Key: "quiet", Bare: true},
+	}
+	if !reflect.DeepEqual(cmdline, expected) {
+		t.Fatalf("Expected %v, got %v", expected, cmdline)
+	}
+
+	if cmdline.Contains(types.TuneArgument{Key: "nosmt", Bare: true}) {
+		t.Errorf("Expected bare nosmt not to match nosmt=force")
+	}
+	if !cmdline.Contains(types.ParseTuneArgument(`dyndbg="file drivers/* +p"`)) {
+		t.Errorf("Expected the quoted dyndbg value to match")
+	}
+	if values := cmdline.Values("console"); !reflect.DeepEqual(values, []string{"tty0", "ttyS0,115200n8"}) {
+		t.Errorf("Expected both console values, got %v", values)
+	}
+	if arg := (types.TuneArgument{Key: "dyndbg", Value: "file drivers/* +p"}); arg.String() != `dyndbg="file drivers/* +p"` {
+		t.Errorf("Expected the value to be quoted, got %s", arg)
+	}
+}
+
+func TestNextBootCmdLine(t *testing.T) {
+	entries, err := ioutil.TempDir("", "entries")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(entries)
+	for name, content := range map[string]string{
+		"ostree-1-rhcos.conf": "title Red Hat CoreOS (ostree:1)\nversion 1\noptions root=UUID=abcd rw quiet\n",
+		"ostree-2-rhcos.conf": "title Red Hat CoreOS (ostree:0)\nversion 2\noptions root=UUID=abcd rw quiet nosmt\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(entries, name), []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree kargs", utils.FakeResponse{Output: "root=UUID=abcd rw isolcpus=2-7\n"})
+	cmdline, err := nextBootCmdLine(r, entries)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cmdline.Contains(types.ParseTuneArgument("isolcpus=2-7")) {
+		t.Fatalf("Expected the arguments from rpm-ostree, got %v", cmdline)
+	}
+
+	// The default boot loader entry is used if rpm-ostree fails
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree kargs", utils.FakeResponse{ExitCode: 1})
+	if cmdline, err = nextBootCmdLine(r, entries); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cmdline.Contains(types.ParseTuneArgument("nosmt")) {
+		t.Fatalf("Expected the arguments of version 2, got %v", cmdline)
+	}
+	if _, err = nextBootCmdLine(r, filepath.Join(entries, "missing")); err == nil {
+		t.Fatalf("Expected an error without boot loader entries")
+	}
+}
@@ -0,0 +1,234 @@
+package pivot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/utils"
+)
+
+// CommitCandidate is an OSTree commit found in an image repo
+type CommitCandidate struct {
+	Checksum string   `json:"checksum"`          // The commit checksum
+	Refs     []string `json:"refs,omitempty"`    // The refs pointing at or bound to the commit
+	Version  string   `json:"version,omitempty"` // The version metadata of the commit
+}
+
+// String describes the candidate for error messages
+func (c CommitCandidate) String() string {
+	details := []string{}
+	if len(c.Refs) > 0 {
+		details = append(details, "refs: "+strings.Join(c.Refs, ", "))
+	}
+	if c.Version != "" {
+		details = append(details, "version: "+c.Version)
+	}
+	if len(details) == 0 {
+		return c.Checksum
+	}
+	return fmt.Sprintf("%s (%s)", c.Checksum, strings.Join(details, "; "))
+}
+
+// AmbiguousCommitError lists the commits which could be rebased to when
+// more than one was found. Choose one with Options.Ref or Options.Commit.
+type AmbiguousCommitError struct {
+	Candidates []CommitCandidate
+}
+
+// Error implements the error interface
+func (e *AmbiguousCommitError) Error() string {
+	candidates := []string{}
+	for _, c := range e.Candidates {
+		candidates = append(candidates, c.String())
+	}
+	return fmt.Sprintf("choose one of %s", strings.Join(candidates, ", "))
+}
+
+// commitFinder discovers the commit to rebase to in an image repo
+type commitFinder struct {
+	r       *utils.Runner
+	repo    string
+	version string // The version label of the image, if any
+}
+
+// metadata returns the string value of the commit metadata key, or an
+// empty string if it is not set
+func (f *commitFinder) metadata(csum, key string) string {
+	out, err := f.r.RunGetOut("ostree", "show", "--repo", f.repo, "--print-metadata-key="+key, csum)
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
+// candidate describes csum, which the refs point at
+func (f *commitFinder) candidate(csum string, refs ...string) CommitCandidate {
+	c := CommitCandidate{Checksum: csum, Refs: refs}
+	c.Version = strings.Trim(f.metadata(csum, "version"), "'")
+	if len(c.Refs) == 0 {
+		// Commits may record the refs they are meant for, formatted as a
+		// GVariant string array: ['a', 'b']
+		for _, ref := range strings.Split(strings.Trim(f.metadata(csum, "ostree.ref-binding"), "[]"), ",") {
+			if ref = strings.Trim(strings.TrimSpace(ref), "'"); ref != "" {
+				c.Refs = append(c.Refs, ref)
+			}
+		}
+	}
+	return c
+}
+
+// refs returns a candidate for each ref in the repo
+func (f *commitFinder) refs() ([]CommitCandidate, error) {
+	out, err := f.r.RunGetOut("ostree", "refs", "--repo", f.repo)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []CommitCandidate{}
+	if out == "" {
+		return candidates, nil
+	}
+	for _, ref := range strings.Split(out, "\n") {
+		csum, err := f.r.RunGetOut("ostree", "rev-parse", "--repo", f.repo, ref)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, f.candidate(csum, ref))
+	}
+	return candidates, nil
+}
+
+// scan returns a candidate for each commit object in the repo
+func (f *commitFinder) scan() ([]CommitCandidate, error) {
+	objects := filepath.Join(f.repo, "objects")
+	dirs, err := ioutil.ReadDir(objects)
+	if err != nil {
+		return nil, err
+	}
+	checksums := []string{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(objects, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".commit") {
+				checksums = append(checksums, dir.Name()+strings.TrimSuffix(file.Name(), ".commit"))
+			}
+		}
+	}
+	sort.Strings(checksums)
+	candidates := []CommitCandidate{}
+	for _, csum := range checksums {
+		candidates = append(candidates, f.candidate(csum))
+	}
+	return candidates, nil
+}
+
+// disambiguate narrows candidates down to those with the version of the
+// image, then to those which are not the parent of another candidate
+func (f *commitFinder) disambiguate(candidates []CommitCandidate) []CommitCandidate {
+	if f.version != "" {
+		matching := []CommitCandidate{}
+		for _, c := range candidates {
+			if c.Version == f.version {
+				matching = append(matching, c)
+			}
+		}
+		if len(matching) > 0 {
+			candidates = matching
+		}
+	}
+	if len(candidates) < 2 {
+		return candidates
+	}
+	parents := map[string]bool{}
+	for _, c := range candidates {
+		if parent, err := f.r.RunGetOut("ostree", "rev-parse", "--repo", f.repo, c.Checksum+"^"); err == nil {
+			parents[parent] = true
+		}
+	}
+	tips := []CommitCandidate{}
+	for _, c := range candidates {
+		if !parents[c.Checksum] {
+			tips = append(tips, c)
+		}
+	}
+	if len(tips) == 0 {
+		return candidates
+	}
+	return tips
+}
+
+// withRef returns the candidates pointed at by or bound to ref
+func withRef(candidates []CommitCandidate, ref string) []CommitCandidate {
+	matching := []CommitCandidate{}
+	for _, c := range candidates {
+		for _, r := range c.Refs {
+			if r == ref {
+				matching = append(matching, c)
+				break
+			}
+		}
+	}
+	return matching
+}
+
+// findCommit returns the commit to rebase to in repo. An explicit commit
+// or ref is used if given, then the com.coreos.ostree-commit label, then
+// the refs in the repo and finally the commit objects in the repo.
+func findCommit(r *utils.Runner, repo string, labels map[string]string, commit, ref string) (string, error) {
+	f := &commitFinder{r: r, repo: repo, version: labels["version"]}
+	if commit != "" {
+		csum, err := r.RunGetOut("ostree", "rev-parse", "--repo", repo, commit)
+		if err != nil {
+			return "", newError(ErrNoCommit, fmt.Errorf("commit %s not found: %v", commit, err))
+		}
+		return csum, nil
+	}
+	if ref == "" {
+		if csum, ok := labels["com.coreos.ostree-commit"]; ok {
+			return csum, nil
+		}
+		glog.Infof("No com.coreos.ostree-commit label found in metadata! Inspecting...")
+	}
+	candidates, err := f.refs()
+	if err != nil {
+		return "", newError(ErrNoCommit, err)
+	}
+	kind := ErrMultipleRefs
+	if ref != "" && len(withRef(candidates, ref)) == 0 {
+		// The ref may only be recorded in the commit metadata
+		candidates = nil
+	}
+	if len(candidates) == 0 {
+		glog.Infof("No refs found in repo; scanning for commit objects")
+		if candidates, err = f.scan(); err != nil && !os.IsNotExist(err) {
+			return "", newError(ErrNoCommit, err)
+		}
+		kind = ErrMultipleCommits
+	}
+	if ref != "" {
+		if candidates = withRef(candidates, ref); len(candidates) == 0 {
+			return "", newError(ErrNoCommit, fmt.Errorf("ref %s not found", ref))
+		}
+	}
+	if len(candidates) > 1 {
+		candidates = f.disambiguate(candidates)
+	}
+	switch len(candidates) {
+	case 0:
+		return "", newError(ErrNoCommit, nil)
+	case 1:
+		glog.Infof("Using commit %s", candidates[0])
+		return candidates[0].Checksum, nil
+	}
+	return "", newError(kind, &AmbiguousCommitError{Candidates: candidates})
+}
@@ -0,0 +1,112 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift/pivot/utils"
+)
+
+// writeTestRepo creates an OSTree repo containing empty commit objects
+func writeTestRepo(t *testing.T, commits ...string) string {
+	repo, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, csum := range commits {
+		dir := filepath.Join(repo, "objects", csum[:2])
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, csum[2:]+".commit"), nil, 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		// Other objects are ignored
+		if err := ioutil.WriteFile(filepath.Join(dir, csum[2:]+".dirtree"), nil, 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	return repo
+}
+
+func TestFindCommit(t *testing.T) {
+	repo := writeTestRepo(t, "aaaa", "bbbb", "cccc")
+	defer os.RemoveAll(repo)
+
+	// A single ref is used
+	r, fake := newFakeRunner()
+	fake.On("ostree refs", utils.FakeResponse{Output: "os\n"}).
+		On("ostree rev-parse --repo "+repo+" os", utils.FakeResponse{Output: "dddd\n"})
+	if csum, err := findCommit(r, repo, nil, "", ""); err != nil || csum != "dddd" {
+		t.Fatalf("Expected dddd, got %s %v", csum, err)
+	}
+
+	// The label is used unless a ref is chosen
+	labels := map[string]string{"com.coreos.ostree-commit": "eeee"}
+	if csum, err := findCommit(r, repo, labels, "", ""); err != nil || csum != "eeee" {
+		t.Fatalf("Expected eeee, got %s %v", csum, err)
+	}
+	if csum, err := findCommit(r, repo, labels, "", "os"); err != nil || csum != "dddd" {
+		t.Fatalf("Expected dddd, got %s %v", csum, err)
+	}
+
+	// Without refs the commit objects are scanned and all are reported
+	// when none can be chosen
+	r, fake = newFakeRunner()
+	_, err := findCommit(r, repo, nil, "", "")
+	pivotErr, ok := err.(*Error)
+	if !ok || pivotErr.Kind != ErrMultipleCommits {
+		t.Fatalf("Expected ErrMultipleCommits, got %v", err)
+	}
+	ambiguous, ok := pivotErr.Err.(*AmbiguousCommitError)
+	if !ok || len(ambiguous.Candidates) != 3 || ambiguous.Candidates[0].Checksum != "aaaa" {
+		t.Fatalf("Expected the 3 commits as candidates, got %v", err)
+	}
+
+	// The version label chooses between them
+	fake.On("ostree show --repo "+repo+" --print-metadata-key=version bbbb", utils.FakeResponse{Output: "'42.1'\n"})
+	if csum, err := findCommit(r, repo, map[string]string{"version": "42.1"}, "", ""); err != nil || csum != "bbbb" {
+		t.Fatalf("Expected bbbb, got %s %v", csum, err)
+	}
+
+	// Otherwise the commit which is not a parent of another is used
+	r, fake = newFakeRunner()
+	fake.On("ostree rev-parse --repo "+repo+" aaaa^", utils.FakeResponse{ExitCode: 1}).
+		On("ostree rev-parse --repo "+repo+" bbbb^", utils.FakeResponse{Output: "aaaa\n"}).
+		On("ostree rev-parse --repo "+repo+" cccc^", utils.FakeResponse{Output: "bbbb\n"})
+	if csum, err := findCommit(r, repo, nil, "", ""); err != nil || csum != "cccc" {
+		t.Fatalf("Expected cccc, got %s %v", csum, err)
+	}
+
+	// A ref bound in the commit metadata chooses the commit
+	r, fake = newFakeRunner()
+	fake.On("ostree show --repo "+repo+" --print-metadata-key=ostree.ref-binding aaaa", utils.FakeResponse{Output: "['rhcos/x86_64', 'os']\n"})
+	if csum, err := findCommit(r, repo, nil, "", "os"); err != nil || csum != "aaaa" {
+		t.Fatalf("Expected aaaa, got %s %v", csum, err)
+	}
+	_, err = findCommit(r, repo, nil, "", "other")
+	if pivotErr, ok := err.(*Error); !ok || pivotErr.Kind != ErrNoCommit {
+		t.Fatalf("Expected ErrNoCommit, got %v", err)
+	}
+
+	// An explicit commit must exist
+	r, fake = newFakeRunner()
+	fake.On("ostree rev-parse --repo "+repo+" bb", utils.FakeResponse{Output: "bbbb\n"}).
+		On("ostree rev-parse --repo "+repo+" ffff", utils.FakeResponse{ExitCode: 1})
+	if csum, err := findCommit(r, repo, labels, "bb", ""); err != nil || csum != "bbbb" {
+		t.Fatalf("Expected bbbb, got %s %v", csum, err)
+	}
+	if _, err := findCommit(r, repo, nil, "ffff", ""); err == nil {
+		t.Fatalf("Expected an error for a missing commit")
+	}
+
+	// An empty repo has no commit
+	empty := writeTestRepo(t)
+	defer os.RemoveAll(empty)
+	_, err = findCommit(r, empty, nil, "", "")
+	if pivotErr, ok := err.(*Error); !ok || pivotErr.Kind != ErrNoCommit {
+		t.Fatalf("Expected ErrNoCommit, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package pivot
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPull is the Kind of errors from pulling or inspecting the image
+	ErrPull = errors.New("unable to pull image")
+	// ErrPlatform is the Kind of errors when the image is for another architecture or OS
+	ErrPlatform = errors.New("image is not for this platform")
+	// ErrPolicy is the Kind of errors when the image does not satisfy the signature policy
+	ErrPolicy = errors.New("image rejected by signature policy")
+	// ErrNoCommit is the Kind of errors when no OSTree commit can be found in the image
+	ErrNoCommit = errors.New("no OSTree commit found in image")
+	// ErrMultipleRefs is the Kind of errors when the image repo has more than one ref
+	ErrMultipleRefs = errors.New("multiple refs found in image repo")
+	// ErrMultipleCommits is the Kind of errors when the image repo has no refs and more than one commit
+	ErrMultipleCommits = errors.New("multiple commits found in image repo")
+	// ErrRebase is the Kind of errors from rebasing to the OSTree commit
+	ErrRebase = errors.New("unable to rebase")
+	// ErrTuning is the Kind of errors from applying kernel argument tuning
+	ErrTuning = errors.New("unable to tune kernel arguments")
+	// ErrRollback is the Kind of errors from rolling back to the previous deployment
+	ErrRollback = errors.New("unable to roll back")
+	// ErrVerify is the Kind of errors when the booted deployment could not be verified
+	ErrVerify = errors.New("verification failed")
+	// ErrHook is the Kind of errors when a hook which must succeed failed
+	ErrHook = errors.New("hook failed")
+	// ErrReboot is the Kind of errors from rebooting or scheduling a reboot
+	ErrReboot = errors.New("unable to reboot")
+)
+
+// Error is the error type returned by Pivot. Kind is one of the Err* values
+// and identifies the step which failed.
+type Error struct {
+	Kind error // The class of failure
+	Err  error // The underlying error, may be nil
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the Kind of this error
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// newError wraps err as an Error of the given kind
+func newError(kind, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
@@ -0,0 +1,164 @@
+package pivot
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/types"
+)
+
+// Outcomes of a pivot attempt recorded in the history
+const (
+	// HistoryChanged is recorded when the system was rebased or tuned
+	HistoryChanged = "changed"
+	// HistoryUnchanged is recorded when the system was already as requested
+	HistoryUnchanged = "unchanged"
+	// HistoryFailed is recorded when the attempt failed
+	HistoryFailed = "failed"
+)
+
+// HistoryEntry records a single attempt to pivot
+type HistoryEntry struct {
+	Pullspec           string                  `json:"pullspec,omitempty"`           // The image requested, if any
+	ImageID            string                  `json:"imageID,omitempty"`            // The image resolved to name@digest
+	Digest             string                  `json:"digest,omitempty"`             // The resolved image digest
+	Commit             string                  `json:"commit,omitempty"`             // The OSTree commit of the image
+	Version            string                  `json:"version,omitempty"`            // The version label of the image
+	Rebased            bool                    `json:"rebased"`                      // If the system was rebased to the image
+	KernelArgsAdded    []types.TuneArgument    `json:"kernelArgsAdded,omitempty"`    // Kernel arguments appended
+	KernelArgsDeleted  []types.TuneArgument    `json:"kernelArgsDeleted,omitempty"`  // Kernel arguments deleted
+	KernelArgsReplaced []types.TuneReplacement `json:"kernelArgsReplaced,omitempty"` // Kernel arguments given a new value
+	Hooks              []HookResult            `json:"hooks,omitempty"`              // The hooks run and their output
+	Started            time.Time               `json:"started"`                      // When the attempt started
+	Finished           time.Time               `json:"finished"`                     // When the attempt finished
+	Outcome            string                  `json:"outcome"`                      // HistoryChanged, HistoryUnchanged or HistoryFailed
+	ErrorKind          string                  `json:"errorKind,omitempty"`          // The Kind of the *Error the attempt failed with
+	Error              string                  `json:"error,omitempty"`              // Why the attempt failed
+}
+
+// Duration returns how long the attempt took
+func (e HistoryEntry) Duration() time.Duration {
+	return e.Finished.Sub(e.Started)
+}
+
+// newHistoryEntry records the outcome of pivoting to pullspec
+func newHistoryEntry(pullspec string, res Result, err error, started, finished time.Time) HistoryEntry {
+	entry := HistoryEntry{
+		Pullspec: pullspec,
+		ImageID:  res.ImageID,
+		Digest:   res.Digest,
+		Commit:   res.Commit,
+		Version:  res.Version,
+		Rebased:  res.Rebased,
+		Hooks:    res.Hooks,
+		Started:  started.UTC(),
+		Finished: finished.UTC(),
+		Outcome:  HistoryUnchanged,
+	}
+	// Kernel arguments are only changed once the tuning is applied
+	if res.TuningChanged {
+		entry.KernelArgsAdded = res.KernelArgsAdded
+		entry.KernelArgsDeleted = res.KernelArgsDeleted
+		entry.KernelArgsReplaced = res.KernelArgsReplaced
+	}
+	if res.Changed {
+		entry.Outcome = HistoryChanged
+	}
+	if err != nil {
+		entry.Outcome = HistoryFailed
+		entry.Error = err.Error()
+		if pivotErr, ok := err.(*Error); ok {
+			entry.ErrorKind = pivotErr.Kind.Error()
+		}
+	}
+	return entry
+}
+
+// appendHistory appends entry to the history at path as a line of JSON,
+// creating the file if needed
+func appendHistory(path string, entry HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// maxHistoryLine is the longest line ReadHistory parses. Entries are far
+// smaller, so a longer line is not one pivot wrote in full.
+const maxHistoryLine = 1024 * 1024
+
+// readHistoryLine reads the next line without its newline. A line longer
+// than maxHistoryLine is read to its end but returned as nil with tooLong set.
+func readHistoryLine(reader *bufio.Reader) (line []byte, tooLong bool, err error) {
+	for {
+		fragment, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return nil, false, err
+		}
+		if !tooLong {
+			line = append(line, fragment...)
+			if len(line) > maxHistoryLine {
+				line, tooLong = nil, true
+			}
+		}
+		if !isPrefix {
+			return line, tooLong, nil
+		}
+	}
+}
+
+// ReadHistory returns the attempts recorded in the history at path, oldest
+// first. A missing history is empty, and lines which cannot be parsed, such
+// as one cut short by a crash or one too long to read, are skipped.
+func ReadHistory(path string) ([]HistoryEntry, error) {
+	entries := []HistoryEntry{}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for lineNumber := 1; ; lineNumber++ {
+		line, tooLong, err := readHistoryLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+		if tooLong {
+			glog.Warningf("Skipping line %d of %s: longer than %d bytes", lineNumber, path, maxHistoryLine)
+			continue
+		}
+		if len(line) == 0 {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			glog.Warningf("Skipping line %d of %s: %v", lineNumber, path, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,101 @@
+package pivot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift/pivot/utils"
+)
+
+func TestHistory(t *testing.T) {
+	server, reg := newTestRegistry()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	historyFile := filepath.Join(dir, "state", "history.jsonl")
+	cmdLineFile := filepath.Join(dir, "cmdline")
+	if err := ioutil.WriteFile(cmdLineFile, []byte("BOOT_IMAGE=/vmlinuz quiet"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	opts := Options{
+		Image:          testDigestRef,
+		TuningFile:     filepath.Join(dir, "missing"),
+		KernelArgsFile: filepath.Join(dir, "missing.json"),
+		CmdLineFile:    cmdLineFile,
+		KernelArgs:     []string{"ADD nosmt"},
+		HistoryFile:    historyFile,
+		Registry:       reg,
+	}
+
+	// Already at the image, but tuned
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "abcd", "version": "42.1", "custom-origin": ["pivot://` + testDigestRef + `", ""]}]}`})
+	opts.Runner = r
+	if _, err := Pivot(context.Background(), opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Dry runs are not recorded
+	opts.DryRun = true
+	if _, err := Pivot(context.Background(), opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	opts.DryRun = false
+
+	// A failed rebase
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{}]}`}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"Labels": {"com.coreos.ostree-commit": "ef01"}}]`}).
+		On("rpm-ostree rebase", utils.FakeResponse{ExitCode: 1})
+	opts.Runner = r
+	if _, err := Pivot(context.Background(), opts); err == nil {
+		t.Fatalf("Expected an error")
+	}
+
+	// A line cut short by a crash is skipped
+	f, err := os.OpenFile(historyFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	f.WriteString(`{"pullspec": "registry.exa` + "\n")
+	// So is a line too long to read, without losing those after it
+	f.WriteString(`{"pullspec": "` + strings.Repeat("x", maxHistoryLine) + `"}` + "\n")
+	f.Close()
+	if err := appendHistory(historyFile, HistoryEntry{Pullspec: "after", Outcome: HistoryUnchanged}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	entries, err := ReadHistory(historyFile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(entries) != 3 || entries[2].Pullspec != "after" {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	first := entries[0]
+	if first.Outcome != HistoryChanged || first.Pullspec != testDigestRef || first.Commit != "abcd" || first.Version != "42.1" || first.Rebased {
+		t.Fatalf("Expected a tuning change at the booted commit, got %+v", first)
+	}
+	if len(first.KernelArgsAdded) != 1 || first.KernelArgsAdded[0].Key != "nosmt" {
+		t.Fatalf("Expected nosmt to be recorded as added, got %+v", first.KernelArgsAdded)
+	}
+	if first.Started.IsZero() || first.Duration() < 0 {
+		t.Fatalf("Expected the timings to be recorded, got %+v", first)
+	}
+	second := entries[1]
+	if second.Outcome != HistoryFailed || second.ErrorKind != ErrRebase.Error() || second.Error == "" || len(second.KernelArgsAdded) != 0 {
+		t.Fatalf("Expected a failed rebase, got %+v", second)
+	}
+
+	// A missing history is empty
+	if entries, err = ReadHistory(filepath.Join(dir, "missing.jsonl")); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected no entries, got %+v %v", entries, err)
+	}
+}
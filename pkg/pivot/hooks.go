@@ -0,0 +1,125 @@
+package pivot
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/utils"
+)
+
+// The stages hooks are run at, each a subdirectory of HooksDir
+const (
+	// HookPrePull runs before the image is pulled. A failure aborts the pivot.
+	HookPrePull = "pre-pull"
+	// HookPreRebase runs once the commit is found, before rebasing. A failure aborts the pivot.
+	HookPreRebase = "pre-rebase"
+	// HookPostRebase runs after rebasing. A failure is only reported.
+	HookPostRebase = "post-rebase"
+	// HookPreReboot runs before pivot reboots the system. A failure aborts the reboot.
+	HookPreReboot = "pre-reboot"
+)
+
+// HookEnv describes the deployments a hook runs between, passed to hooks
+// as PIVOT_* environment variables
+type HookEnv struct {
+	CurrentImage   string // The image of the current deployment, empty if not managed by pivot
+	CurrentCommit  string // The OSTree commit of the current deployment
+	CurrentVersion string // The version of the current deployment
+	TargetImage    string // The image being pivoted to
+	TargetCommit   string // The OSTree commit being pivoted to, empty until it is known
+	TargetVersion  string // The version being pivoted to, empty until it is known
+}
+
+// vars returns the environment variables for a hook run at stage
+func (env HookEnv) vars(stage string) []string {
+	return []string{
+		"PIVOT_HOOK=" + stage,
+		"PIVOT_CURRENT_IMAGE=" + env.CurrentImage,
+		"PIVOT_CURRENT_COMMIT=" + env.CurrentCommit,
+		"PIVOT_CURRENT_VERSION=" + env.CurrentVersion,
+		"PIVOT_TARGET_IMAGE=" + env.TargetImage,
+		"PIVOT_TARGET_COMMIT=" + env.TargetCommit,
+		"PIVOT_TARGET_VERSION=" + env.TargetVersion,
+	}
+}
+
+// HookResult reports a hook which was run
+type HookResult struct {
+	Stage  string `json:"stage"`           // The stage the hook ran at
+	Hook   string `json:"hook"`            // The path of the hook
+	Output string `json:"output"`          // The end of what the hook wrote to stdout
+	Error  string `json:"error,omitempty"` // Why the hook failed
+}
+
+// maxHookOutput is how much of the end of its output is kept for each hook
+const maxHookOutput = 4096
+
+// truncateOutput keeps the last maxHookOutput bytes of out, where the
+// reason for a failure is usually found
+func truncateOutput(out string) string {
+	if len(out) <= maxHookOutput {
+		return out
+	}
+	return "[truncated]..." + out[len(out)-maxHookOutput:]
+}
+
+// RunHooks runs the executables in the stage subdirectory of dir in name
+// order, stopping at the first which fails. The results of the hooks run
+// are returned along with the error of any failure.
+func RunHooks(r *utils.Runner, dir, stage string, env HookEnv) ([]HookResult, error) {
+	hooks, err := listExecutables(filepath.Join(dir, stage))
+	if err != nil {
+		return nil, err
+	}
+	results := []HookResult{}
+	for _, hook := range hooks {
+		glog.Infof("Running %s hook %s", stage, hook)
+		out, err := r.RunGetOutEnv(env.vars(stage), hook)
+		if out != "" {
+			glog.Infof("%s: %s", filepath.Base(hook), out)
+		}
+		result := HookResult{Stage: stage, Hook: hook, Output: truncateOutput(out)}
+		if err != nil {
+			if cmdErr, ok := err.(*utils.CommandError); ok {
+				// Keep the error as short as the output
+				truncated := *cmdErr
+				truncated.Stderr = truncateOutput(cmdErr.Stderr)
+				err = &truncated
+			}
+			result.Error = err.Error()
+			results = append(results, result)
+			return results, fmt.Errorf("%s hook %s failed: %v", stage, filepath.Base(hook), err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// RunPreRebootHooks runs the HookPreReboot hooks in opts.HooksDir, from the
+// booted deployment to the one which will be booted next. An error means
+// the system should not be rebooted.
+func RunPreRebootHooks(opts Options) ([]HookResult, error) {
+	opts.complete()
+	deployments, err := getDeployments(opts.Runner)
+	if err != nil {
+		return nil, newError(ErrHook, err)
+	}
+	env := HookEnv{
+		TargetImage:   pivotImage(deployments[0]),
+		TargetCommit:  deployments[0].Checksum,
+		TargetVersion: deployments[0].Version,
+	}
+	for _, deployment := range deployments {
+		if deployment.Booted {
+			env.CurrentImage = pivotImage(deployment)
+			env.CurrentCommit = deployment.Checksum
+			env.CurrentVersion = deployment.Version
+		}
+	}
+	results, err := RunHooks(opts.Runner, opts.HooksDir, HookPreReboot, env)
+	if err != nil {
+		return results, newError(ErrHook, err)
+	}
+	return results, nil
+}
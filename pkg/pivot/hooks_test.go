@@ -0,0 +1,165 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift/pivot/utils"
+)
+
+// writeTestHooks creates executable hooks named stage/name in a new dir
+func writeTestHooks(t *testing.T, hooks ...string) string {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, hook := range hooks {
+		path := filepath.Join(dir, hook)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	return dir
+}
+
+// hookEnv returns the environment variables the hook at path was run with
+func hookEnv(fake *utils.FakeExecutor, path string) string {
+	for i, call := range fake.Calls {
+		if call == path {
+			return strings.Join(fake.Env[i], " ")
+		}
+	}
+	return ""
+}
+
+func TestRunHooks(t *testing.T) {
+	dir := writeTestHooks(t, "pre-pull/10-snapshot", "pre-pull/20-drain", "pre-pull/30-notify=yes")
+	defer os.RemoveAll(dir)
+	env := HookEnv{CurrentCommit: "old", CurrentVersion: "41", TargetImage: testDigestRef}
+	first := filepath.Join(dir, "pre-pull/10-snapshot")
+
+	r, fake := newFakeRunner()
+	fake.On(first, utils.FakeResponse{Output: "ok\n"}).
+		On(filepath.Join(dir, "pre-pull/20-drain"), utils.FakeResponse{Output: strings.Repeat("x", maxHookOutput) + "done"})
+	results, err := RunHooks(r, dir, HookPrePull, env)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Expected 3 hooks to run, got %v %v", results, err)
+	}
+	expected := "PIVOT_HOOK=pre-pull PIVOT_CURRENT_IMAGE= PIVOT_CURRENT_COMMIT=old PIVOT_CURRENT_VERSION=41 PIVOT_TARGET_IMAGE=" + testDigestRef +
+		" PIVOT_TARGET_COMMIT= PIVOT_TARGET_VERSION="
+	if got := hookEnv(fake, first); got != expected {
+		t.Fatalf("Expected %s, got %s", expected, got)
+	}
+	if results[0].Output != "ok" || results[0].Stage != HookPrePull {
+		t.Fatalf("Expected the output of the hook, got %+v", results[0])
+	}
+	if output := results[1].Output; len(output) > maxHookOutput+len("[truncated]...") || !strings.HasSuffix(output, "done") {
+		t.Fatalf("Expected the end of long output to be kept, got %d bytes", len(output))
+	}
+	// A name which looks like a variable is still run as the hook
+	if !fake.Called(filepath.Join(dir, "pre-pull/30-notify=yes")) {
+		t.Fatalf("Expected 30-notify=yes to run, got %v", fake.Calls)
+	}
+
+	// The first failure stops the later hooks
+	r, fake = newFakeRunner()
+	fake.On(first, utils.FakeResponse{ExitCode: 1, Stderr: "workloads still running"})
+	results, err = RunHooks(r, dir, HookPrePull, env)
+	if err == nil || !strings.Contains(err.Error(), "10-snapshot") {
+		t.Fatalf("Expected 10-snapshot to fail, got %v", err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Error, "workloads still running") || len(fake.Calls) != 1 {
+		t.Fatalf("Expected only the failed hook, got %+v %v", results, fake.Calls)
+	}
+
+	// The end of a long error is kept like the output
+	r, fake = newFakeRunner()
+	fake.On(first, utils.FakeResponse{ExitCode: 1, Stderr: strings.Repeat("x", 2*maxHookOutput) + "disk full"})
+	results, err = RunHooks(r, dir, HookPrePull, env)
+	if err == nil || len(results) != 1 {
+		t.Fatalf("Expected only the failed hook, got %+v %v", results, err)
+	}
+	if len(err.Error()) > maxHookOutput+len(first)+100 {
+		t.Fatalf("Expected a truncated error, got %d bytes", len(err.Error()))
+	}
+	if errMsg := results[0].Error; len(errMsg) > maxHookOutput+len(first)+100 || !strings.HasSuffix(errMsg, "disk full") {
+		t.Fatalf("Expected the end of long stderr to be kept, got %d bytes", len(errMsg))
+	}
+
+	// Stages without hooks do nothing
+	r, fake = newFakeRunner()
+	if results, err = RunHooks(r, dir, HookPreReboot, env); err != nil || len(results) != 0 || len(fake.Calls) != 0 {
+		t.Fatalf("Expected no hooks, got %v %v %v", results, err, fake.Calls)
+	}
+}
+
+func TestPullAndRebaseHooks(t *testing.T) {
+	server, reg := newTestRegistry()
+	defer server.Close()
+	dir := writeTestHooks(t, "pre-pull/check", "pre-rebase/check", "post-rebase/check")
+	defer os.RemoveAll(dir)
+	status := `{"deployments": [{"checksum": "old", "version": "41"}]}`
+
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: status}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"Labels": {"com.coreos.ostree-commit": "abcd", "version": "42"}}]`}).
+		On("podman mount", utils.FakeResponse{Output: "/mnt/cid"})
+	res, err := pullAndRebase(Options{Image: testDigestRef, Runner: r, Registry: reg, HooksDir: dir})
+	if err != nil || !res.Rebased || len(res.Hooks) != 3 {
+		t.Fatalf("Expected a rebase running 3 hooks, got %+v %v", res, err)
+	}
+	expected := "PIVOT_HOOK=pre-rebase PIVOT_CURRENT_IMAGE= PIVOT_CURRENT_COMMIT=old PIVOT_CURRENT_VERSION=41 PIVOT_TARGET_IMAGE=" + testDigestRef + " PIVOT_TARGET_COMMIT=abcd PIVOT_TARGET_VERSION=42"
+	if got := hookEnv(fake, filepath.Join(dir, "pre-rebase/check")); got != expected {
+		t.Fatalf("Expected the pre-rebase hook to know the commit, got %s", got)
+	}
+
+	// A failed pre-rebase hook aborts before rebasing
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: status}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"Labels": {"com.coreos.ostree-commit": "abcd"}}]`}).
+		On(filepath.Join(dir, "pre-rebase/check"), utils.FakeResponse{ExitCode: 1})
+	res, err = pullAndRebase(Options{Image: testDigestRef, Runner: r, Registry: reg, HooksDir: dir})
+	if pivotErr, ok := err.(*Error); !ok || !pivotErr.Is(ErrHook) {
+		t.Fatalf("Expected ErrHook, got %v", err)
+	}
+	if res.Rebased || fake.Called("rpm-ostree rebase") || len(res.Hooks) != 2 {
+		t.Fatalf("Expected no rebase, got %+v %v", res, fake.Calls)
+	}
+
+	// A failed pre-pull hook aborts before pulling
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: status}).
+		On(filepath.Join(dir, "pre-pull/check"), utils.FakeResponse{ExitCode: 1})
+	if _, err = pullAndRebase(Options{Image: testDigestRef, Runner: r, Registry: reg, HooksDir: dir}); err == nil || fake.Called("podman pull") {
+		t.Fatalf("Expected no pull, got %v %v", err, fake.Calls)
+	}
+
+	// A failed post-rebase hook is only reported
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: status}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"Labels": {"com.coreos.ostree-commit": "abcd"}}]`}).
+		On(filepath.Join(dir, "post-rebase/check"), utils.FakeResponse{ExitCode: 1})
+	res, err = pullAndRebase(Options{Image: testDigestRef, Runner: r, Registry: reg, HooksDir: dir})
+	if err != nil || !res.Rebased || res.Hooks[2].Error == "" {
+		t.Fatalf("Expected a rebase reporting the failed hook, got %+v %v", res, err)
+	}
+
+	// Pre-reboot hooks run from the booted deployment to the next
+	dir = writeTestHooks(t, "pre-reboot/drain")
+	defer os.RemoveAll(dir)
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "new", "custom-origin": ["pivot://` + testDigestRef + `", ""]}, {"checksum": "old", "booted": true}]}`})
+	if _, err := RunPreRebootHooks(Options{Runner: r, Registry: reg, HooksDir: dir}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected = "PIVOT_HOOK=pre-reboot PIVOT_CURRENT_IMAGE= PIVOT_CURRENT_COMMIT=old PIVOT_CURRENT_VERSION= PIVOT_TARGET_IMAGE=" + testDigestRef + " PIVOT_TARGET_COMMIT=new PIVOT_TARGET_VERSION="
+	if got := hookEnv(fake, filepath.Join(dir, "pre-reboot/drain")); got != expected {
+		t.Fatalf("Expected the pre-reboot hook from old to new, got %s", got)
+	}
+}
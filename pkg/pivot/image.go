@@ -0,0 +1,334 @@
+package pivot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	// Enable sha256 in container image references
+	_ "crypto/sha256"
+
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+	"github.com/openshift/pivot/pkg/registry"
+	"github.com/openshift/pivot/pkg/signature"
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+
+	imgref "github.com/containers/image/docker/reference"
+)
+
+// getDeployments uses rpm-ostree status --json to get the deployments
+func getDeployments(r *utils.Runner) ([]types.RpmOstreeDeployment, error) {
+	// use --status for now, we can switch to D-Bus if we need more info
+	var rosState types.RpmOstreeState
+	output, err := r.RunGetOut("rpm-ostree", "status", "--json")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(output), &rosState); err != nil {
+		return nil, fmt.Errorf("failed to parse `rpm-ostree status --json` output: %v", err)
+	}
+
+	// just make it a hard error if we somehow don't have any deployments
+	if len(rosState.Deployments) == 0 {
+		return nil, fmt.Errorf("not currently booted in a deployment")
+	}
+
+	return rosState.Deployments, nil
+}
+
+// getDefaultDeployment uses rpm-ostree status --json to get the current deployment
+func getDefaultDeployment(r *utils.Runner) (types.RpmOstreeDeployment, error) {
+	deployments, err := getDeployments(r)
+	if err != nil {
+		return types.RpmOstreeDeployment{}, err
+	}
+	return deployments[0], nil
+}
+
+// pivotImage returns the image a deployment was pivoted to from its
+// pivot:// custom origin, or an empty string if pivot did not create it
+func pivotImage(deployment types.RpmOstreeDeployment) string {
+	if len(deployment.CustomOrigin) > 0 {
+		if strings.HasPrefix(deployment.CustomOrigin[0], "pivot://") {
+			return deployment.CustomOrigin[0][len("pivot://"):]
+		}
+	}
+	return ""
+}
+
+// getRefDigest parses a Docker/OCI image reference and returns
+// its digest, or an error if the string fails to parse as
+// a "canonical" image reference with a digest.
+func getRefDigest(ref string) (string, error) {
+	refParsed, err := imgref.ParseNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("parsing reference: %q: %v", ref, err)
+	}
+	canon, ok := refParsed.(imgref.Canonical)
+	if !ok {
+		return "", fmt.Errorf("not canonical form: %q: %v", ref, err)
+	}
+
+	return canon.Digest().String(), nil
+}
+
+// compareOSImageURL determines whether two images are the same, or have
+// matching digests.
+func compareOSImageURL(current, desired string) (bool, error) {
+	if current == desired {
+		return true, nil
+	}
+
+	currentDigest, err := getRefDigest(current)
+	if err != nil {
+		return false, fmt.Errorf("parsing current osImageURL: %v", err)
+	}
+	desiredDigest, err := getRefDigest(desired)
+	if err != nil {
+		return false, fmt.Errorf("parsing desired osImageURL: %v", err)
+	}
+
+	if currentDigest == desiredDigest {
+		glog.Infof("Current and target osImageURL have matching digest %q", currentDigest)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// withDigest returns container in name@digest form
+func withDigest(container string, dgst digest.Digest) (string, error) {
+	named, err := imgref.ParseNamed(container)
+	if err != nil {
+		return "", fmt.Errorf("parsing reference: %q: %v", container, err)
+	}
+	canon, err := imgref.WithDigest(imgref.TrimNamed(named), dgst)
+	if err != nil {
+		return "", err
+	}
+	return canon.String(), nil
+}
+
+// checkPlatform returns an error if imagedata is not for platform. Images
+// which do not declare their platform are allowed.
+func checkPlatform(imagedata types.ImageInspection, platform registry.Platform, ignore bool) error {
+	if imagedata.Architecture == "" || imagedata.Os == "" {
+		glog.Warningf("Image does not declare its platform; assuming it is for %s", platform)
+		return nil
+	}
+	imagePlatform := registry.Platform{Architecture: imagedata.Architecture, OS: imagedata.Os}
+	if imagePlatform.Matches(platform) {
+		return nil
+	}
+	err := fmt.Errorf("image is for %s but this system is %s", imagePlatform, platform)
+	if ignore {
+		glog.Warningf("Ignoring platform mismatch: %v", err)
+		return nil
+	}
+	return newError(ErrPlatform, err)
+}
+
+// pullError wraps an error from fetching an image in the Error of its kind
+func pullError(err error) *Error {
+	if _, ok := err.(*registry.PlatformError); ok {
+		return newError(ErrPlatform, err)
+	}
+	return newError(ErrPull, err)
+}
+
+// verifyImage checks image satisfies the signature policy of verifier, if any
+func verifyImage(verifier *signature.Verifier, image string) error {
+	if verifier == nil {
+		return nil
+	}
+	if err := verifier.Verify(image); err != nil {
+		return newError(ErrPolicy, err)
+	}
+	return nil
+}
+
+// planRebase resolves container and reports whether pullAndRebase would
+// rebase to it without pulling the image or changing the system.
+func planRebase(opts Options, container, previousPivot string) (Result, error) {
+	res := Result{DryRun: true}
+	img, err := opts.Registry.GetImage(container)
+	if err != nil {
+		return res, pullError(err)
+	}
+	imagedata := img.Inspection
+	res.ImageID = container
+	if _, err := getRefDigest(container); err != nil {
+		res.ImageID = img.Ref.String()
+	}
+	res.Digest, _ = getRefDigest(res.ImageID)
+	glog.Infof("Resolved to: %s", res.ImageID)
+	if err := checkPlatform(imagedata, opts.Registry.Platform, opts.IgnorePlatform); err != nil {
+		return res, err
+	}
+	if err := verifyImage(opts.Verifier, res.ImageID); err != nil {
+		return res, err
+	}
+
+	res.Rebased = true
+	if previousPivot != "" {
+		targetMatched, err := compareOSImageURL(previousPivot, res.ImageID)
+		if err != nil {
+			return res, err
+		}
+		res.Rebased = !targetMatched
+	}
+	res.Changed = res.Rebased
+
+	// Without the label the commit can only be found by mounting the image
+	res.Commit = opts.Commit
+	if res.Commit == "" && opts.Ref == "" {
+		res.Commit = imagedata.Labels["com.coreos.ostree-commit"]
+	}
+	res.Version = imagedata.Labels["version"]
+	return res, nil
+}
+
+// pullAndRebase potentially rebases system to opts.Image if not already
+// rebased. The returned Result has Rebased and Changed set if a rebase
+// occurred. With opts.DryRun the image is resolved but not pulled, and the
+// system is not rebased. The hooks in opts.HooksDir are run around the pull
+// and rebase, and are not run if there is nothing to do.
+func pullAndRebase(opts Options) (Result, error) {
+	var res Result
+	opts.complete()
+	r := opts.Runner
+	container := opts.Image
+	defaultDeployment, err := getDefaultDeployment(r)
+	if err != nil {
+		return res, err
+	}
+
+	previousPivot := pivotImage(defaultDeployment)
+	if previousPivot != "" {
+		glog.Infof("Previous pivot: %s", previousPivot)
+	}
+
+	// If we're passed a non-canonical image, resolve it to its sha256 now
+	isCanonicalForm := true
+	if _, err := getRefDigest(container); err != nil {
+		isCanonicalForm = false
+		// Ask the registry where the tag points so the pull can be skipped
+		// if we are already there
+		dgst, err := opts.Registry.ResolveDigest(container)
+		if err != nil {
+			glog.Warningf("Unable to resolve %s in the registry, pulling instead: %v", container, err)
+		} else if resolved, err := withDigest(container, dgst); err == nil {
+			glog.Infof("Resolved to: %s", resolved)
+			container = resolved
+			isCanonicalForm = true
+		}
+	}
+	if isCanonicalForm && previousPivot != "" {
+		targetMatched, err := compareOSImageURL(previousPivot, container)
+		if err != nil {
+			return res, err
+		}
+		if targetMatched {
+			res.ImageID = container
+			res.Digest, _ = getRefDigest(container)
+			res.Commit = defaultDeployment.Checksum
+			res.Version = defaultDeployment.Version
+			res.DryRun = opts.DryRun
+			return res, nil
+		}
+	}
+
+	if opts.DryRun {
+		return planRebase(opts, container, previousPivot)
+	}
+
+	env := HookEnv{
+		CurrentImage:   previousPivot,
+		CurrentCommit:  defaultDeployment.Checksum,
+		CurrentVersion: defaultDeployment.Version,
+		TargetImage:    container,
+		TargetCommit:   opts.Commit,
+	}
+	hooks, err := RunHooks(r, opts.HooksDir, HookPrePull, env)
+	res.Hooks = append(res.Hooks, hooks...)
+	if err != nil {
+		return res, newError(ErrHook, err)
+	}
+
+	// Pull the image
+	imagedata, err := opts.Provider.Pull(container)
+	if err != nil {
+		return res, pullError(err)
+	}
+	if !isCanonicalForm {
+		if len(imagedata.RepoDigests) == 0 {
+			return res, newError(ErrPull, fmt.Errorf("unable to resolve %s to a digest", container))
+		}
+		res.ImageID = imagedata.RepoDigests[0]
+		glog.Infof("Resolved to: %s", res.ImageID)
+	} else {
+		res.ImageID = container
+	}
+	res.Digest, _ = getRefDigest(res.ImageID)
+	if err := checkPlatform(imagedata, opts.Registry.Platform, opts.IgnorePlatform); err != nil {
+		return res, err
+	}
+	if err := verifyImage(opts.Verifier, res.ImageID); err != nil {
+		return res, err
+	}
+
+	mnt, release, err := opts.Provider.Mount(res.ImageID)
+	if err != nil {
+		return res, newError(ErrPull, err)
+	}
+	defer release()
+	repo := fmt.Sprintf("%s/srv/repo", mnt)
+
+	// Now we need to figure out the commit to rebase to
+	ostree_csum, err := findCommit(r, repo, imagedata.Labels, opts.Commit, opts.Ref)
+	if err != nil {
+		return res, err
+	}
+	if ostree_version, ok := imagedata.Labels["version"]; ok {
+		res.Version = ostree_version
+		glog.Infof("Pivoting to: %s (%s)", ostree_version, ostree_csum)
+	} else {
+		glog.Infof("Pivoting to: %s", ostree_csum)
+	}
+	res.Commit = ostree_csum
+
+	env.TargetImage = res.ImageID
+	env.TargetCommit = res.Commit
+	env.TargetVersion = res.Version
+	hooks, err = RunHooks(r, opts.HooksDir, HookPreRebase, env)
+	res.Hooks = append(res.Hooks, hooks...)
+	if err != nil {
+		return res, newError(ErrHook, err)
+	}
+
+	// This will be what will be displayed in `rpm-ostree status` as the "origin spec"
+	customURL := fmt.Sprintf("pivot://%s", res.ImageID)
+
+	// RPM-OSTree can now directly slurp from the mounted image!
+	// https://github.com/projectatomic/rpm-ostree/pull/1732
+	err = r.Run("rpm-ostree", "rebase", "--experimental",
+		fmt.Sprintf("%s:%s", repo, ostree_csum),
+		"--custom-origin-url", customURL,
+		"--custom-origin-description", "Managed by pivot tool")
+	if err != nil {
+		return res, newError(ErrRebase, err)
+	}
+
+	res.Rebased = true
+	res.Changed = true
+
+	// The rebase is done, so a failing hook cannot undo it
+	hooks, err = RunHooks(r, opts.HooksDir, HookPostRebase, env)
+	res.Hooks = append(res.Hooks, hooks...)
+	if err != nil {
+		glog.Warningf("%v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,169 @@
+package pivot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openshift/pivot/pkg/registry/registrytest"
+	"github.com/openshift/pivot/pkg/signature"
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+func mustCompareOSImageURL(t *testing.T, refA, refB string) bool {
+	m, err := compareOSImageURL(refA, refB)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return m
+}
+
+func TestCompareOSImageURL(t *testing.T) {
+	refA := "registry.example.com/foo/bar@sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f"
+	refB := "registry.example.com/foo/baz@sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f"
+	refC := "registry.example.com/foo/bar@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff"
+	if !mustCompareOSImageURL(t, refA, refA) {
+		t.Fatalf("Expected refA ident")
+	}
+	if !mustCompareOSImageURL(t, refA, refB) {
+		t.Fatalf("Expected refA = refB")
+	}
+	if mustCompareOSImageURL(t, refA, refC) {
+		t.Fatalf("Expected refA != refC")
+	}
+	m, err := compareOSImageURL(refA, "registry.example.com/foo/bar")
+	if m || err == nil {
+		t.Fatalf("Expected err")
+	}
+}
+
+func TestPullAndRebase(t *testing.T) {
+	server, reg := newTestRegistry()
+	defer server.Close()
+	taggedRef := "registry.example.com/os@" + server.AddImage("os", "booted", registrytest.Config{}).String()
+
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"custom-origin": ["pivot://registry.example.com/os@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff", ""]}]}`}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"Labels": {"com.coreos.ostree-commit": "abcd", "version": "42.1"}}]`}).
+		On("podman create", utils.FakeResponse{Output: "cid\n"}).
+		On("podman mount", utils.FakeResponse{Output: "/mnt/cid\n"})
+
+	res, err := pullAndRebase(Options{Image: testDigestRef, Runner: r, Registry: reg})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !res.Changed || res.ImageID != testDigestRef {
+		t.Fatalf("Expected a change to %s, got %v %s", testDigestRef, res.Changed, res.ImageID)
+	}
+	if res.Commit != "abcd" || res.Version != "42.1" {
+		t.Fatalf("Expected commit abcd version 42.1, got %s %s", res.Commit, res.Version)
+	}
+	if !fake.Called("rpm-ostree rebase --experimental /mnt/cid/srv/repo:abcd --custom-origin-url pivot://" + testDigestRef) {
+		t.Fatalf("Expected a rebase, got %v", fake.Calls)
+	}
+	if last := fake.Calls[len(fake.Calls)-1]; !strings.HasPrefix(last, "podman rm -f "+types.PivotNamePrefix) {
+		t.Fatalf("Expected the dummy container to be removed, got %v", fake.Calls)
+	}
+
+	// Already at the target
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"custom-origin": ["pivot://` + testDigestRef + `", ""]}]}`})
+	if res, err = pullAndRebase(Options{Image: testDigestRef, Runner: r, Registry: reg}); err != nil || res.Changed {
+		t.Fatalf("Expected no change and no error, got %v %v", res.Changed, err)
+	}
+	if fake.Called("podman pull") {
+		t.Fatalf("Did not expect a pull, got %v", fake.Calls)
+	}
+
+	// A tag pointing at the booted digest is not pulled
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"custom-origin": ["pivot://` + taggedRef + `", ""]}]}`})
+	if res, err = pullAndRebase(Options{Image: "registry.example.com/os:booted", Runner: r, Registry: reg}); err != nil || res.Changed {
+		t.Fatalf("Expected no change and no error, got %v %v", res.Changed, err)
+	}
+	if res.ImageID != taggedRef || fake.Called("podman pull") {
+		t.Fatalf("Expected %s without a pull, got %s %v", taggedRef, res.ImageID, fake.Calls)
+	}
+
+	// A tag pointing elsewhere is pulled by digest
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"custom-origin": ["pivot://registry.example.com/os@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff", ""]}]}`}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"Labels": {"com.coreos.ostree-commit": "abcd"}}]`})
+	if res, err = pullAndRebase(Options{Image: "registry.example.com/os:booted", Runner: r, Registry: reg}); err != nil || !res.Changed {
+		t.Fatalf("Expected a change and no error, got %v %v", res.Changed, err)
+	}
+	if !fake.Called("podman pull -q " + taggedRef) {
+		t.Fatalf("Expected a pull of %s, got %v", taggedRef, fake.Calls)
+	}
+
+	// A failed pull is returned rather than exiting
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{}]}`}).
+		On("podman pull", utils.FakeResponse{ExitCode: 125, Stderr: "manifest unknown"})
+	_, err = pullAndRebase(Options{Image: "registry.example.com/os:latest", Runner: r, Registry: reg})
+	pivotErr, ok := err.(*Error)
+	if !ok || pivotErr.Kind != ErrPull {
+		t.Fatalf("Expected ErrPull, got %v", err)
+	}
+	cmdErr, ok := pivotErr.Err.(*utils.CommandError)
+	if !ok || cmdErr.ExitCode != 125 || cmdErr.Stderr != "manifest unknown" {
+		t.Fatalf("Expected the podman pull error, got %v", err)
+	}
+	if fake.Called("rpm-ostree rebase") {
+		t.Fatalf("Did not expect a rebase, got %v", fake.Calls)
+	}
+
+	// Multiple refs without a commit label is an error
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{}]}`}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"RepoDigests": ["` + testDigestRef + `"]}]`}).
+		On("podman mount", utils.FakeResponse{Output: "/mnt/cid"}).
+		On("ostree refs", utils.FakeResponse{Output: "a\nb\n"})
+	_, err = pullAndRebase(Options{Image: "registry.example.com/os:latest", Runner: r, Registry: reg})
+	if pivotErr, ok := err.(*Error); !ok || pivotErr.Kind != ErrMultipleRefs {
+		t.Fatalf("Expected ErrMultipleRefs, got %v", err)
+	}
+
+	// An image for another architecture is only rebased to if asked to
+	for _, ignore := range []bool{false, true} {
+		r, fake = newFakeRunner()
+		fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{}]}`}).
+			On("podman inspect", utils.FakeResponse{Output: `[{"Architecture": "s390x", "Os": "linux", "Labels": {"com.coreos.ostree-commit": "abcd"}}]`})
+		_, err = pullAndRebase(Options{Image: testDigestRef, Runner: r, Registry: reg, IgnorePlatform: ignore})
+		if ignore {
+			if err != nil || !fake.Called("rpm-ostree rebase") {
+				t.Fatalf("Expected a rebase ignoring the platform, got %v %v", err, fake.Calls)
+			}
+			continue
+		}
+		if pivotErr, ok := err.(*Error); !ok || pivotErr.Kind != ErrPlatform {
+			t.Fatalf("Expected ErrPlatform, got %v", err)
+		}
+		if fake.Called("podman mount") {
+			t.Fatalf("Did not expect a mount, got %v", fake.Calls)
+		}
+	}
+
+	// An image rejected by the signature policy is not mounted or rebased to
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{}]}`}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"Labels": {"com.coreos.ostree-commit": "abcd"}}]`})
+	policy := &signature.Policy{Default: []signature.Requirement{{Type: signature.TypeReject}}}
+	_, err = pullAndRebase(Options{Image: testDigestRef, Runner: r, Registry: reg, Verifier: signature.NewVerifier(policy, reg, r, "")})
+	if pivotErr, ok := err.(*Error); !ok || pivotErr.Kind != ErrPolicy {
+		t.Fatalf("Expected ErrPolicy, got %v", err)
+	}
+	if fake.Called("podman mount") || fake.Called("rpm-ostree rebase") {
+		t.Fatalf("Did not expect a mount or rebase, got %v", fake.Calls)
+	}
+}
+
+func TestPodmanRemove(t *testing.T) {
+	r, fake := newFakeRunner()
+	fake.On("podman kill", utils.FakeResponse{ExitCode: 125})
+	podmanRemove(r, "foo")
+	if len(fake.Calls) != 2 || fake.Calls[1] != "podman rm -f foo" {
+		t.Fatalf("Expected kill and rm, got %v", fake.Calls)
+	}
+
+}
@@ -0,0 +1,232 @@
+package pivot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/types"
+)
+
+// DesiredKernelArgs is the format of DesiredKernelArgsFile
+type DesiredKernelArgs struct {
+	// KernelArgs is the full set of kernel arguments pivot owns. Arguments
+	// pivot added which are no longer listed are removed.
+	KernelArgs []string `json:"kernelArgs"`
+}
+
+// ownedKernelArgs is the format of KernelArgsStateFile
+type ownedKernelArgs struct {
+	// Owned are the kernel arguments pivot added for DesiredKernelArgsFile
+	Owned []string `json:"owned"`
+}
+
+// tuningPlan is the kernel argument changes from the tuning files
+type tuningPlan struct {
+	additions      []types.TuneArgument
+	deletions      []types.TuneArgument
+	replacements   []types.TuneReplacement
+	owned          []types.TuneArgument // The arguments pivot owns once the plan is applied
+	recordOwned    bool                 // If owned should be saved, as there is a desired state file
+	tuningChecksum string               // The checksum of the tuning file, empty if there is none
+	tuningSkipped  bool                 // If the tuning file was already applied
+	applied        *AppliedTuning       // The record of the last tuning file applied
+}
+
+// newTuningPlan returns a plan with no changes
+func newTuningPlan() tuningPlan {
+	return tuningPlan{
+		additions:    []types.TuneArgument{},
+		deletions:    []types.TuneArgument{},
+		replacements: []types.TuneReplacement{},
+	}
+}
+
+// readJSONFile parses the JSON file at path into v
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return nil
+}
+
+// parseArgs parses each of the kernel arguments
+func parseArgs(args []string) []types.TuneArgument {
+	parsed := []types.TuneArgument{}
+	for _, arg := range args {
+		parsed = append(parsed, types.ParseTuneArgument(arg))
+	}
+	return parsed
+}
+
+// readDesiredKernelArgs reads the desired kernel arguments from path
+func readDesiredKernelArgs(path string) ([]types.TuneArgument, error) {
+	var desired DesiredKernelArgs
+	if err := readJSONFile(path, &desired); err != nil {
+		return nil, err
+	}
+	return parseArgs(desired.KernelArgs), nil
+}
+
+// readOwnedKernelArgs reads the kernel arguments pivot owns from path. If
+// there is no record pivot owns nothing.
+func readOwnedKernelArgs(path string) ([]types.TuneArgument, error) {
+	var owned ownedKernelArgs
+	if err := readJSONFile(path, &owned); err != nil {
+		if os.IsNotExist(err) {
+			return []types.TuneArgument{}, nil
+		}
+		return nil, err
+	}
+	return parseArgs(owned.Owned), nil
+}
+
+// writeOwnedKernelArgs records the kernel arguments pivot owns to path
+func writeOwnedKernelArgs(path string, args []types.TuneArgument) error {
+	owned := ownedKernelArgs{Owned: []string{}}
+	for _, arg := range args {
+		owned.Owned = append(owned.Owned, arg.String())
+	}
+	return writeJSONFile(path, owned)
+}
+
+// containsArg checks if args has arg
+func containsArg(args []types.TuneArgument, arg types.TuneArgument) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// appendMissing appends the arguments from more which are not in args
+func appendMissing(args []types.TuneArgument, more ...types.TuneArgument) []types.TuneArgument {
+	for _, arg := range more {
+		if !containsArg(args, arg) {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+// diffDesiredKernelArgs returns the arguments to add to cmdline to reach
+// desired and the arguments pivot owns which are no longer desired.
+// Arguments which were already present are not taken ownership of, so are
+// never deleted.
+func diffDesiredKernelArgs(desired, owned []types.TuneArgument, cmdline CmdLine, allowlist *Allowlist) ([]types.TuneArgument, []types.TuneArgument) {
+	additions := []types.TuneArgument{}
+	deletions := []types.TuneArgument{}
+	for _, arg := range desired {
+		if !allowlist.Allowed(arg) {
+			logNotAllowed(allowlist, arg)
+			continue
+		}
+		if !cmdline.Contains(arg) {
+			additions = appendMissing(additions, arg)
+		}
+	}
+	for _, arg := range owned {
+		if containsArg(desired, arg) || !cmdline.Contains(arg) {
+			continue
+		}
+		if allowlist.Denied(arg) {
+			logNotAllowed(allowlist, arg)
+			continue
+		}
+		deletions = appendMissing(deletions, arg)
+	}
+	return additions, deletions
+}
+
+// planTuning combines the changes from the imperative tuning file and the
+// desired state file, relative to the kernel arguments of the deployment
+// which boots next
+func planTuning(opts *Options) (tuningPlan, error) {
+	if err := opts.loadAllowlist(); err != nil {
+		return tuningPlan{}, err
+	}
+	var cmdline CmdLine
+	cmdLine := func() (CmdLine, error) {
+		if cmdline != nil {
+			return cmdline, nil
+		}
+		var err error
+		if opts.CmdLineFile != "" {
+			cmdline, err = ReadCmdLine(opts.CmdLineFile)
+		} else {
+			cmdline, err = nextBootCmdLine(opts.Runner, BootEntriesDir)
+		}
+		return cmdline, err
+	}
+
+	checksum, err := fileChecksum(opts.TuningFile)
+	if err != nil && !os.IsNotExist(err) {
+		return tuningPlan{}, err
+	}
+	applied, err := readAppliedTuning(opts.TuningStateFile)
+	if err != nil {
+		return tuningPlan{}, err
+	}
+	var plan tuningPlan
+	if checksum != "" && applied != nil && applied.Checksum == checksum && applied.Outcome == TuningApplied {
+		// Only a new tuning file is applied, so one which was already
+		// applied is not reapplied after the arguments are changed by hand
+		glog.Infof("Skipping %s as it is unchanged since it was applied at %s", opts.TuningFile, applied.Time)
+		plan = newTuningPlan()
+		plan.tuningSkipped = true
+	} else if plan, err = parseTuningArgs(opts.TuningFile, cmdLine, opts.Allowlist, opts.ProfileDirs); err != nil && !os.IsNotExist(err) {
+		return plan, err
+	}
+	plan.tuningChecksum = checksum
+	plan.applied = applied
+
+	if len(opts.KernelArgs) > 0 {
+		cmdline, err := cmdLine()
+		if err != nil {
+			return plan, err
+		}
+		ops, problems, err := parseTuningOps(strings.NewReader(strings.Join(opts.KernelArgs, "\n")), opts.Allowlist, opts.ProfileDirs)
+		if err != nil {
+			return plan, err
+		}
+		logTuningProblems(kernelArgsSource, problems)
+		plan.addOps(ops, cmdline)
+	}
+
+	desired, err := readDesiredKernelArgs(opts.KernelArgsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			glog.V(2).Infof("no desired kernel arguments as %s does not exist", opts.KernelArgsFile)
+			return plan, nil
+		}
+		return plan, err
+	}
+	owned, err := readOwnedKernelArgs(opts.KernelArgsStateFile)
+	if err != nil {
+		return plan, err
+	}
+	if _, err := cmdLine(); err != nil {
+		return plan, err
+	}
+	additions, deletions := diffDesiredKernelArgs(desired, owned, cmdline, opts.Allowlist)
+	plan.additions = appendMissing(plan.additions, additions...)
+	plan.deletions = appendMissing(plan.deletions, deletions...)
+
+	// Keep owning what is still desired, and take ownership of what is added
+	plan.owned = []types.TuneArgument{}
+	for _, arg := range appendMissing(owned, additions...) {
+		if containsArg(desired, arg) && !containsArg(plan.deletions, arg) {
+			plan.owned = append(plan.owned, arg)
+		}
+	}
+	plan.recordOwned = true
+	return plan, nil
+}
@@ -0,0 +1,113 @@
+package pivot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+func TestDesiredKernelArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kargs")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	cmdLineFile := filepath.Join(dir, "cmdline")
+	desiredFile := filepath.Join(dir, "kernel-args.json")
+	stateFile := filepath.Join(dir, "state", "kernel-args-owned.json")
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	opts := Options{
+		TuningFile:          filepath.Join(dir, "missing"),
+		CmdLineFile:         cmdLineFile,
+		KernelArgsFile:      desiredFile,
+		KernelArgsStateFile: stateFile,
+	}
+
+	// isolcpus=0-1 and nosmt were set by someone else and hugepages=16 by
+	// pivot in an earlier run
+	write(cmdLineFile, "BOOT_IMAGE=/vmlinuz quiet isolcpus=0-1 nosmt hugepages=16")
+	write(desiredFile, `{"kernelArgs": ["isolcpus=2-7", "hugepagesz=1G", "nosmt", "root=/dev/sda"]}`)
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	write(stateFile, `{"owned": ["hugepages=16"]}`)
+
+	plan, err := planTuning(&opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expectedAdd := []types.TuneArgument{{Key: "isolcpus", Value: "2-7"}, {Key: "hugepagesz", Value: "1G"}}
+	if !reflect.DeepEqual(plan.additions, expectedAdd) {
+		t.Fatalf("Expected additions %v, got %v", expectedAdd, plan.additions)
+	}
+	expectedDelete := []types.TuneArgument{{Key: "hugepages", Value: "16"}}
+	if !reflect.DeepEqual(plan.deletions, expectedDelete) {
+		t.Fatalf("Expected deletions %v, got %v", expectedDelete, plan.deletions)
+	}
+
+	// Applying the plan records what pivot now owns
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"custom-origin": ["pivot://` + testDigestRef + `", ""]}]}`})
+	opts.Image = testDigestRef
+	opts.Runner = r
+	res, err := Pivot(context.Background(), opts)
+	if err != nil || !res.TuningChanged {
+		t.Fatalf("Expected a tuning change and no error, got %+v %v", res, err)
+	}
+	kargsCalls := []string{}
+	for _, call := range fake.Calls {
+		if strings.HasPrefix(call, "rpm-ostree kargs") {
+			kargsCalls = append(kargsCalls, call)
+		}
+	}
+	expectedCalls := []string{"rpm-ostree kargs --delete=hugepages=16 --append=isolcpus=2-7 --append=hugepagesz=1G"}
+	if !reflect.DeepEqual(kargsCalls, expectedCalls) {
+		t.Fatalf("Expected a single transaction %v, got %v", expectedCalls, kargsCalls)
+	}
+	owned, err := readOwnedKernelArgs(stateFile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(owned, expectedAdd) {
+		t.Fatalf("Expected to own %v, got %v", expectedAdd, owned)
+	}
+
+	// Arguments pivot added are removed once no longer desired, but those
+	// set by someone else are left alone
+	write(cmdLineFile, "BOOT_IMAGE=/vmlinuz quiet isolcpus=0-1 nosmt isolcpus=2-7 hugepagesz=1G")
+	write(desiredFile, `{"kernelArgs": ["hugepagesz=1G"]}`)
+	plan, err = planTuning(&opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expectedDelete = []types.TuneArgument{{Key: "isolcpus", Value: "2-7"}}
+	if len(plan.additions) != 0 || !reflect.DeepEqual(plan.deletions, expectedDelete) {
+		t.Fatalf("Expected to only delete %v, got %v %v", expectedDelete, plan.additions, plan.deletions)
+	}
+	if expectedOwned := []types.TuneArgument{{Key: "hugepagesz", Value: "1G"}}; !reflect.DeepEqual(plan.owned, expectedOwned) {
+		t.Fatalf("Expected to own %v, got %v", expectedOwned, plan.owned)
+	}
+
+	// Without a command line file the deployment which boots next is used
+	opts.CmdLineFile = ""
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree kargs", utils.FakeResponse{Output: "quiet isolcpus=0-1 nosmt hugepagesz=1G"})
+	opts.Runner = r
+	if plan, err = planTuning(&opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(plan.additions) != 0 || len(plan.deletions) != 0 {
+		t.Fatalf("Expected no changes to the pending deployment, got %v %v", plan.additions, plan.deletions)
+	}
+}
@@ -0,0 +1,264 @@
+// Package pivot moves an OSTree based system to the OSTree commit embedded
+// in a container image (an oscontainer) and applies kernel argument tuning.
+package pivot
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/registry"
+	"github.com/openshift/pivot/pkg/signature"
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+const (
+	// the number of times to retry commands that pull data from the network
+	numRetriesNetCommands = 5
+	// EtcPivotFile holds an image pullspec to pivot to when none is given
+	EtcPivotFile = "/etc/pivot/image-pullspec"
+	// RequestsDir queues JSON Requests to be processed in name order
+	RequestsDir = "/etc/pivot/requests.d"
+	// ResultsDir holds a RequestResult for each processed request
+	ResultsDir = "/run/pivot/results"
+	// RunPivotRebootFile requests a reboot after a change when it exists. It
+	// may contain the RebootPolicy to use.
+	RunPivotRebootFile = "/run/pivot/reboot-needed"
+	// RebootRequiredFile is written by the marker RebootPolicy for an external coordinator
+	RebootRequiredFile = "/run/pivot/reboot-required"
+	// RebootPolicyFile holds the default RebootPolicy
+	RebootPolicyFile = "/etc/pivot/reboot-policy"
+	// KubeletAuthFile is the pull secret.  Written by the machine-config-operator
+	KubeletAuthFile = "/var/lib/kubelet/config.json"
+	// KernelTuningFile contains kernel arg changes for tuning
+	KernelTuningFile = "/etc/pivot/kernel-args"
+	// DesiredKernelArgsFile lists the full set of kernel arguments pivot owns
+	DesiredKernelArgsFile = "/etc/pivot/kernel-args.json"
+	// CmdLineFile is the kernel command line of the booted system
+	CmdLineFile = "/proc/cmdline"
+	// BootEntriesDir holds the boot loader entries of the deployments
+	BootEntriesDir = "/boot/loader/entries"
+	// ImageExtractDir is where images are extracted without podman. It is
+	// on disk rather than in memory as oscontainers are large.
+	ImageExtractDir = "/var/tmp"
+	// SignaturePolicyFile is the containers-policy.json(5) enforced before rebasing
+	SignaturePolicyFile = "/etc/containers/policy.json"
+	// RegistriesDir holds the registries.d(5) signature lookaside configuration
+	RegistriesDir = "/etc/containers/registries.d"
+	// LibAllowlistDir holds the kernel argument allowlist drop-ins shipped with the OS
+	LibAllowlistDir = "/usr/lib/pivot/kernel-args-allowlist.d"
+	// EtcAllowlistDir holds kernel argument allowlist drop-ins which override LibAllowlistDir
+	EtcAllowlistDir = "/etc/pivot/kernel-args-allowlist.d"
+	// LibProfilesDir holds the kernel tuning profiles shipped with the OS
+	LibProfilesDir = "/usr/lib/pivot/profiles"
+	// EtcProfilesDir holds kernel tuning profiles which override LibProfilesDir
+	EtcProfilesDir = "/etc/pivot/profiles"
+	// StateDir holds state pivot keeps between runs
+	StateDir = "/var/lib/pivot"
+	// KernelArgsStateFile records the kernel arguments pivot added for DesiredKernelArgsFile
+	KernelArgsStateFile = StateDir + "/kernel-args-owned.json"
+	// TuningStateFile records the checksum and outcome of the last KernelTuningFile applied
+	TuningStateFile = StateDir + "/kernel-args-applied.json"
+	// HistoryFile records each pivot attempt, one JSON HistoryEntry per line
+	HistoryFile = StateDir + "/history.jsonl"
+	// TargetFile records the deployment pivot expects to boot, for Verify
+	TargetFile = StateDir + "/target.json"
+	// HealthDir holds the health check executables run by Verify
+	HealthDir = "/etc/pivot/health.d"
+	// HooksDir holds a subdirectory of executables for each hook stage, such as pre-pull
+	HooksDir = "/etc/pivot/hooks.d"
+	// RollbackRecordFile records the last rollback performed by pivot
+	RollbackRecordFile = StateDir + "/last-rollback.json"
+)
+
+// Options configures a call to Pivot
+type Options struct {
+	Image               string              // The oscontainer pullspec to pivot to, empty to only tune kernel arguments
+	Keep                bool                // Do not remove the container image
+	DryRun              bool                // Only report what would be done
+	TuningFile          string              // The kernel tuning file, defaults to KernelTuningFile
+	KernelArgs          []string            // Kernel tuning file lines applied along with TuningFile
+	CmdLineFile         string              // Tune against this command line instead of that of the next boot
+	KernelArgsFile      string              // The desired kernel arguments, defaults to DesiredKernelArgsFile
+	KernelArgsStateFile string              // Records the kernel arguments pivot owns, defaults to KernelArgsStateFile
+	TuningStateFile     string              // Records the last tuning file applied, defaults to TuningStateFile
+	Runner              *utils.Runner       // Runs external commands, defaults to the host
+	Registry            *registry.Client    // Queries image registries, defaults to using KubeletAuthFile
+	Provider            ImageProvider       // Fetches and mounts images, defaults to using podman
+	Verifier            *signature.Verifier // Enforces the signature policy, nil to not verify images
+	Allowlist           *Allowlist          // The kernel arguments which may be tuned, defaults to the drop-ins
+	AllowlistDirs       []string            // Where the allowlist drop-ins are read from, defaults to LibAllowlistDir and EtcAllowlistDir
+	ProfileDirs         []string            // Where tuning profiles are read from, defaults to LibProfilesDir and EtcProfilesDir
+	Commit              string              // The OSTree commit in the image to rebase to, discovered if empty
+	Ref                 string              // The OSTree ref in the image whose commit is rebased to
+	IgnorePlatform      bool                // Rebase even if the image is for another architecture or OS
+	StrictTuning        bool                // Fail before changing anything if the tuning file has invalid lines
+	HistoryFile         string              // Where attempts are recorded, empty to not record them
+	TargetFile          string              // Where the deployment rebased to is recorded for Verify, empty to not record it
+	HooksDir            string              // The hooks run around the pivot, defaults to HooksDir
+	PullspecFile        string              // The pending image pullspec reported by GetStatus, defaults to EtcPivotFile
+}
+
+// complete fills in the defaults for unset fields
+func (opts *Options) complete() {
+	if opts.Runner == nil {
+		opts.Runner = utils.NewRunner(nil)
+	}
+	if opts.Registry == nil {
+		opts.Registry = registry.NewClient(KubeletAuthFile)
+	}
+	if opts.Provider == nil {
+		opts.Provider = NewPodmanProvider(opts.Runner)
+	}
+	if opts.TuningFile == "" {
+		opts.TuningFile = KernelTuningFile
+	}
+	if opts.KernelArgsFile == "" {
+		opts.KernelArgsFile = DesiredKernelArgsFile
+	}
+	if opts.KernelArgsStateFile == "" {
+		opts.KernelArgsStateFile = KernelArgsStateFile
+	}
+	if opts.TuningStateFile == "" {
+		opts.TuningStateFile = TuningStateFile
+	}
+	if opts.HooksDir == "" {
+		opts.HooksDir = HooksDir
+	}
+	if opts.ProfileDirs == nil {
+		opts.ProfileDirs = []string{LibProfilesDir, EtcProfilesDir}
+	}
+	if opts.AllowlistDirs == nil {
+		opts.AllowlistDirs = []string{LibAllowlistDir, EtcAllowlistDir}
+	}
+	if opts.PullspecFile == "" {
+		opts.PullspecFile = EtcPivotFile
+	}
+}
+
+// loadAllowlist loads the allowlist drop-ins unless an Allowlist was given
+func (opts *Options) loadAllowlist() error {
+	if opts.Allowlist != nil {
+		return nil
+	}
+	allowlist, err := LoadAllowlist(opts.AllowlistDirs...)
+	if err != nil {
+		return err
+	}
+	opts.Allowlist = allowlist
+	return nil
+}
+
+// Result reports what Pivot did
+type Result struct {
+	ImageID            string                  `json:"imageID"`                // The image in name@digest form
+	Digest             string                  `json:"digest"`                 // The resolved image digest
+	Commit             string                  `json:"commit"`                 // The OSTree commit of the image
+	Version            string                  `json:"version"`                // The version label of the image, if any
+	DryRun             bool                    `json:"dryRun"`                 // If nothing was actually changed
+	Rebased            bool                    `json:"rebased"`                // If the system was rebased to the image
+	Changed            bool                    `json:"changed"`                // If the system was rebased or tuned
+	TuningChanged      bool                    `json:"tuningChanged"`          // If kernel arguments were changed
+	KernelArgsAdded    []types.TuneArgument    `json:"kernelArgsAdded"`        // Kernel arguments appended
+	KernelArgsDeleted  []types.TuneArgument    `json:"kernelArgsDeleted"`      // Kernel arguments deleted
+	KernelArgsReplaced []types.TuneReplacement `json:"kernelArgsReplaced"`     // Kernel arguments given a new value
+	TuningSkipped      bool                    `json:"tuningSkipped"`          // If the tuning file was skipped as it is unchanged since it was applied
+	KernelTuning       *AppliedTuning          `json:"kernelTuning,omitempty"` // The record of the tuning file applied, if any
+	Hooks              []HookResult            `json:"hooks,omitempty"`        // The hooks run and their output
+	RebootRequired     bool                    `json:"rebootRequired"`         // If a reboot is needed to apply changes
+}
+
+// Pivot rebases the system to opts.Image if it is not already there, then
+// applies any kernel argument tuning. Errors are of type *Error. With
+// opts.DryRun the returned Result describes the changes which would be made.
+// Other attempts are recorded in opts.HistoryFile if set, and the deployment
+// rebased to in opts.TargetFile if set.
+func Pivot(ctx context.Context, opts Options) (Result, error) {
+	opts.complete()
+	started := time.Now()
+	res, err := runPivot(ctx, opts)
+	if opts.HistoryFile != "" && !opts.DryRun {
+		entry := newHistoryEntry(opts.Image, res, err, started, time.Now())
+		if err := appendHistory(opts.HistoryFile, entry); err != nil {
+			glog.Warningf("Unable to record history in %s: %v", opts.HistoryFile, err)
+		}
+	}
+	if opts.TargetFile != "" && !opts.DryRun && err == nil && res.Rebased {
+		if err := writeJSONFile(opts.TargetFile, newVerifyTarget(res.ImageID, res.Commit)); err != nil {
+			glog.Warningf("Unable to record the target in %s: %v", opts.TargetFile, err)
+		}
+	}
+	return res, err
+}
+
+// runPivot implements Pivot for completed opts
+func runPivot(ctx context.Context, opts Options) (Result, error) {
+	r := opts.Runner
+
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	if opts.StrictTuning {
+		if err := opts.validateTuning(); err != nil {
+			return Result{}, newError(ErrTuning, err)
+		}
+	}
+	res := Result{DryRun: opts.DryRun}
+	if opts.Image != "" {
+		var err error
+		if res, err = pullAndRebase(opts); err != nil {
+			if _, ok := err.(*Error); !ok {
+				err = newError(ErrPull, err)
+			}
+			return res, err
+		}
+	}
+
+	// By default, delete the image.
+	if !opts.Keep && !opts.DryRun && res.Rebased {
+		opts.Provider.Remove(res.ImageID)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	// Check to see if we need to tune kernel arguments
+	plan, err := planTuning(&opts)
+	if err != nil {
+		glog.Infof("unable to read kernel tuning: %s", err)
+		return res, newError(ErrTuning, err)
+	}
+	res.KernelArgsAdded = plan.additions
+	res.KernelArgsDeleted = plan.deletions
+	res.KernelArgsReplaced = plan.replacements
+	res.TuningSkipped = plan.tuningSkipped
+	res.KernelTuning = plan.applied
+	tuningChanged := false
+	if opts.DryRun {
+		tuningChanged = len(plan.additions) > 0 || len(plan.deletions) > 0 || len(plan.replacements) > 0
+	} else {
+		tuningChanged, err = applyTuningArgs(r, plan)
+		if err == nil && plan.recordOwned {
+			err = writeOwnedKernelArgs(opts.KernelArgsStateFile, plan.owned)
+		}
+		if plan.tuningChecksum != "" && !plan.tuningSkipped {
+			res.KernelTuning = newAppliedTuning(opts.TuningFile, plan, err)
+			if err := writeJSONFile(opts.TuningStateFile, res.KernelTuning); err != nil {
+				glog.Warningf("Unable to record kernel tuning in %s: %v", opts.TuningStateFile, err)
+			}
+		}
+	}
+	// If tuning changes but the oscontainer didn't we still denote we changed
+	// for the reboot
+	if tuningChanged {
+		res.TuningChanged = true
+		res.Changed = true
+	}
+	res.RebootRequired = res.Changed
+	if err != nil {
+		glog.Infof("unable to apply kernel tuning: %s", err)
+		return res, newError(ErrTuning, err)
+	}
+	return res, nil
+}
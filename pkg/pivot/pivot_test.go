@@ -0,0 +1,223 @@
+package pivot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift/pivot/pkg/registry"
+	"github.com/openshift/pivot/pkg/registry/registrytest"
+	"github.com/openshift/pivot/utils"
+)
+
+// writeTestFile writes out a file to use in the test
+func writeTestFile(content []byte) (filePath string, err error) {
+	tmpfile, err := ioutil.TempFile("", "testFile")
+	if err != nil {
+		return "", err
+	}
+	filePath = tmpfile.Name()
+	if _, err := tmpfile.Write(content); err != nil {
+		return filePath, err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return filePath, err
+	}
+	return filePath, nil
+}
+
+// newFakeRunner returns a Runner backed by a FakeExecutor which does not
+// sleep between retries
+func newFakeRunner() (*utils.Runner, *utils.FakeExecutor) {
+	fake := utils.NewFakeExecutor()
+	r := utils.NewRunner(fake)
+	r.RetryDelay = 0
+	return r, fake
+}
+
+// newTestRegistry starts a registry stand-in serving registry.example.com
+// to a client selecting linux/amd64 images, which does not sleep between
+// retries or read the host registries.conf
+func newTestRegistry() (*registrytest.Server, *registry.Client) {
+	server := registrytest.NewServer()
+	reg := registry.NewClient("")
+	reg.RetryDelay = 0
+	reg.RegistriesFile = ""
+	reg.Platform = registry.Platform{Architecture: "amd64", OS: "linux"}
+	reg.HTTPClient = server.Client()
+	reg.Endpoints = map[string]string{"registry.example.com": server.URL}
+	return server, reg
+}
+
+const testDigestRef = "registry.example.com/os@sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f"
+
+func TestPivot(t *testing.T) {
+	server, reg := newTestRegistry()
+	defer server.Close()
+
+	cmdLineFileMock, err := writeTestFile([]byte("BOOT_IMAGE=/a/vmlinuz.x86_64 quiet"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", cmdLineFileMock, err)
+	}
+	defer os.Remove(cmdLineFileMock)
+	testFilePath, err := writeTestFile([]byte("ADD nosmt"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	defer os.Remove(testFilePath)
+	stateFile := testFilePath + ".applied"
+	defer os.Remove(stateFile)
+	dir, err := ioutil.TempDir("", "pivot")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{
+		Image:               testDigestRef,
+		TuningFile:          testFilePath,
+		CmdLineFile:         cmdLineFileMock,
+		TuningStateFile:     stateFile,
+		KernelArgsFile:      filepath.Join(dir, "kernel-args.json"),
+		KernelArgsStateFile: filepath.Join(dir, "kernel-args-owned.json"),
+		AllowlistDirs:       []string{filepath.Join(dir, "kernel-args-allowlist.d")},
+		ProfileDirs:         []string{filepath.Join(dir, "profiles")},
+		HooksDir:            filepath.Join(dir, "hooks.d"),
+		Registry:            reg,
+	}
+
+	// Already at the target image, but tuning still requires a reboot
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "abcd", "custom-origin": ["pivot://` + testDigestRef + `", ""]}]}`})
+	opts.Runner = r
+	res, err := Pivot(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !res.Changed || !res.TuningChanged || !res.RebootRequired {
+		t.Fatalf("Expected a tuning change requiring reboot, got %+v", res)
+	}
+	if res.Commit != "abcd" || res.Digest != "sha256:0743a3cc3bcf3b4aabb814500c2739f84cb085ff4e7ec7996aef7977c4c19c7f" {
+		t.Fatalf("Expected the booted commit and digest, got %+v", res)
+	}
+	applied, err := readAppliedTuning(stateFile)
+	if err != nil || applied == nil || applied.Outcome != TuningApplied || applied.File != testFilePath || len(applied.Added) != 1 {
+		t.Fatalf("Expected the tuning file to be recorded as applied, got %+v %v", applied, err)
+	}
+
+	// The same tuning file is not applied again
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "abcd", "custom-origin": ["pivot://` + testDigestRef + `", ""]}]}`})
+	opts.Runner = r
+	if res, err = Pivot(context.Background(), opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if res.Changed || !res.TuningSkipped || res.KernelTuning == nil || res.KernelTuning.Checksum != applied.Checksum {
+		t.Fatalf("Expected the applied tuning file to be skipped, got %+v", res)
+	}
+	if fake.Called("rpm-ostree kargs") {
+		t.Fatalf("Did not expect tuning, got %v", fake.Calls)
+	}
+
+	// A failure is recorded, and a changed file is applied
+	if err := ioutil.WriteFile(testFilePath, []byte("ADD nosmt\nADD mitigations=off"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "abcd", "custom-origin": ["pivot://` + testDigestRef + `", ""]}]}`}).
+		On("rpm-ostree kargs", utils.FakeResponse{ExitCode: 1})
+	opts.Runner = r
+	if _, err = Pivot(context.Background(), opts); err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if applied, err = readAppliedTuning(stateFile); err != nil || applied.Outcome != TuningFailed || applied.Error == "" {
+		t.Fatalf("Expected the failure to be recorded, got %+v %v", applied, err)
+	}
+
+	// A failed rebase is reported as ErrRebase
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{}]}`}).
+		On("podman inspect", utils.FakeResponse{Output: `[{"Labels": {"com.coreos.ostree-commit": "abcd"}}]`}).
+		On("rpm-ostree rebase", utils.FakeResponse{ExitCode: 1})
+	opts.Runner = r
+	_, err = Pivot(context.Background(), opts)
+	if pivotErr, ok := err.(*Error); !ok || !pivotErr.Is(ErrRebase) {
+		t.Fatalf("Expected ErrRebase, got %v", err)
+	}
+	if fake.Called("rpm-ostree kargs") {
+		t.Fatalf("Did not expect tuning after a failed rebase, got %v", fake.Calls)
+	}
+}
+
+func TestPivotStrictTuning(t *testing.T) {
+	testFilePath, err := writeTestFile([]byte("ADD nosmt\nADD isolcpus=bad"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	defer os.Remove(testFilePath)
+
+	r, fake := newFakeRunner()
+	_, err = Pivot(context.Background(), Options{Image: testDigestRef, TuningFile: testFilePath, StrictTuning: true, Runner: r})
+	if pivotErr, ok := err.(*Error); !ok || !pivotErr.Is(ErrTuning) {
+		t.Fatalf("Expected ErrTuning, got %v", err)
+	}
+	if len(fake.Calls) != 0 {
+		t.Fatalf("Expected to fail before running anything, got %v", fake.Calls)
+	}
+}
+
+func TestPivotDryRun(t *testing.T) {
+	server, reg := newTestRegistry()
+	defer server.Close()
+	config := registrytest.Config{}
+	config.Config.Labels = map[string]string{"com.coreos.ostree-commit": "abcd", "version": "42.1"}
+	latestRef := "registry.example.com/os@" + server.AddImage("os", "latest", config).String()
+
+	cmdLineFileMock, err := writeTestFile([]byte("BOOT_IMAGE=/a/vmlinuz.x86_64 nosmt quiet"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", cmdLineFileMock, err)
+	}
+	defer os.Remove(cmdLineFileMock)
+	testFilePath, err := writeTestFile([]byte("DELETE nosmt"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	defer os.Remove(testFilePath)
+
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"custom-origin": ["pivot://registry.example.com/os@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff", ""]}]}`})
+	res, err := Pivot(context.Background(), Options{
+		Image:       "registry.example.com/os:latest",
+		DryRun:      true,
+		TuningFile:  testFilePath,
+		CmdLineFile: cmdLineFileMock,
+		Runner:      r,
+		Registry:    reg,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !res.DryRun || !res.Rebased || !res.RebootRequired || res.ImageID != latestRef || res.Commit != "abcd" || res.Version != "42.1" {
+		t.Fatalf("Expected a planned rebase to %s, got %+v", latestRef, res)
+	}
+	if len(res.KernelArgsDeleted) != 1 || res.KernelArgsDeleted[0].Key != "nosmt" {
+		t.Fatalf("Expected nosmt to be planned for deletion, got %+v", res.KernelArgsDeleted)
+	}
+	for _, prefix := range []string{"podman", "rpm-ostree rebase", "rpm-ostree kargs"} {
+		if fake.Called(prefix) {
+			t.Fatalf("Did not expect %s in a dry run, got %v", prefix, fake.Calls)
+		}
+	}
+
+	// The tag already points at the booted digest
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"custom-origin": ["pivot://` + latestRef + `", ""]}]}`})
+	res, err = Pivot(context.Background(), Options{Image: "registry.example.com/os:latest", DryRun: true, TuningFile: "/nonexistent", Runner: r, Registry: reg})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if res.Rebased || res.Changed {
+		t.Fatalf("Expected no changes, got %+v", res)
+	}
+}
@@ -0,0 +1,60 @@
+package pivot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/openshift/pivot/types"
+)
+
+// profileName matches valid profile names, which are file names
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// parseProfile reads the kernel arguments of a profile from r. Arguments
+// are separated by whitespace or newlines, and lines starting with # are
+// ignored.
+func parseProfile(r io.Reader) ([]types.TuneArgument, error) {
+	args := []types.TuneArgument{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, arg := range splitCmdLine(line) {
+			args = append(args, types.ParseTuneArgument(arg))
+		}
+	}
+	return args, scanner.Err()
+}
+
+// LoadProfile returns the kernel arguments of the named profile, read from
+// name.conf in the last of dirs which has it. Profiles are normally read
+// from LibProfilesDir, where those shipped with pivot are installed, and
+// EtcProfilesDir.
+func LoadProfile(name string, dirs ...string) ([]types.TuneArgument, error) {
+	if !profileName.MatchString(name) {
+		return nil, fmt.Errorf("invalid profile name %q", name)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		f, err := os.Open(filepath.Join(dirs[i], name+".conf"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		defer f.Close()
+		args, err := parseProfile(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", f.Name(), err)
+		}
+		return args, nil
+	}
+	return nil, fmt.Errorf("profile %q not found", name)
+}
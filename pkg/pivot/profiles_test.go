@@ -0,0 +1,125 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/pivot/types"
+)
+
+// shippedProfilesDir holds the profiles installed to LibProfilesDir
+const shippedProfilesDir = "../../profiles"
+
+func TestLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "lib")
+	etc := filepath.Join(dir, "etc")
+	for path, content := range map[string]string{
+		"lib/realtime.conf": "# Real time isolation\nisolcpus=2-7 nohz_full=2-7\nrcu_nocbs=2-7\n",
+		"etc/realtime.conf": "isolcpus=4-7 nohz_full=4-7\n",
+		"etc/nosmt.conf":    "",
+		"lib/quoted.conf":   `acpi_osi="!Windows 2012"`,
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	for name, expected := range map[string][]types.TuneArgument{
+		"realtime":       {{Key: "isolcpus", Value: "4-7"}, {Key: "nohz_full", Value: "4-7"}},
+		"nosmt":          {},
+		"no-mitigations": {{Key: "mitigations", Value: "off"}},
+		"quoted":         {{Key: "acpi_osi", Value: "!Windows 2012"}},
+	} {
+		args, err := LoadProfile(name, shippedProfilesDir, lib, etc, filepath.Join(dir, "missing"))
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", name, err)
+		}
+		if !reflect.DeepEqual(args, expected) {
+			t.Errorf("Expected %s to be %v, got %v", name, expected, args)
+		}
+	}
+
+	for _, name := range []string{"unknown", "../etc/realtime", ".hidden", ""} {
+		if _, err := LoadProfile(name, lib, etc); err == nil {
+			t.Errorf("Expected an error for %q", name)
+		}
+	}
+
+	// The shipped profiles only use arguments the default allowlist allows
+	profiles, err := filepath.Glob(filepath.Join(shippedProfilesDir, "*.conf"))
+	if err != nil || len(profiles) == 0 {
+		t.Fatalf("Expected shipped profiles, got %v %v", profiles, err)
+	}
+	allowlist := defaultKernelArgsAllowlist()
+	for _, profile := range profiles {
+		name := strings.TrimSuffix(filepath.Base(profile), ".conf")
+		args, err := LoadProfile(name, shippedProfilesDir)
+		if err != nil || len(args) == 0 {
+			t.Fatalf("Expected arguments in %s, got %v %v", name, args, err)
+		}
+		for _, arg := range args {
+			if !allowlist.Allowed(arg) {
+				t.Errorf("Expected %s in %s to be allowed", arg, name)
+			}
+		}
+	}
+}
+
+func TestParseTuningFileProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "realtime.conf"), []byte("isolcpus=2-7 nohz_full=2-7 skew_tick=1\n"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	cmdLineFile := filepath.Join(dir, "cmdline")
+	if err := ioutil.WriteFile(cmdLineFile, []byte("BOOT_IMAGE=/vmlinuz quiet nohz_full=2-7"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	tuningFile := filepath.Join(dir, "kernel-args")
+	if err := ioutil.WriteFile(tuningFile, []byte(strings.Join([]string{
+		"PROFILE realtime",
+		"ADD isolcpus=2-7",
+		"PROFILE nosmt",
+		"PROFILE missing",
+	}, "\n")), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	plan, err := parseTuningArgs(tuningFile, func() (CmdLine, error) { return ReadCmdLine(cmdLineFile) }, nil, []string{shippedProfilesDir, dir})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Arguments from profiles are only added once, and not if in use
+	expected := []types.TuneArgument{{Key: "isolcpus", Value: "2-7"}, {Key: "nosmt", Bare: true}}
+	if !reflect.DeepEqual(plan.additions, expected) {
+		t.Fatalf("Expected additions %v, got %v", expected, plan.additions)
+	}
+
+	err = ValidateTuningFile(tuningFile, nil, shippedProfilesDir, dir)
+	tuningErr, ok := err.(*TuningFileError)
+	if !ok {
+		t.Fatalf("Expected a *TuningFileError, got %v", err)
+	}
+	expectedProblems := []TuningProblem{
+		{Line: 1, Text: "PROFILE realtime", Reason: "profile realtime: skew_tick=1 is not an allowed kernel argument"},
+		{Line: 4, Text: "PROFILE missing", Reason: `profile "missing" not found`},
+	}
+	if !reflect.DeepEqual(tuningErr.Problems, expectedProblems) {
+		t.Fatalf("Expected %v, got %v", expectedProblems, tuningErr.Problems)
+	}
+}
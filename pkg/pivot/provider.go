@@ -0,0 +1,164 @@
+package pivot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/registry"
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// ImageProvider fetches images and makes their content available on the
+// filesystem
+type ImageProvider interface {
+	// Pull fetches the image and returns its metadata
+	Pull(image string) (types.ImageInspection, error)
+	// Mount makes the content of a pulled image available, returning its
+	// root directory and a function which releases it
+	Mount(image string) (string, func(), error)
+	// Remove deletes a pulled image from local storage
+	Remove(image string)
+}
+
+// podmanProvider pulls images into container storage and mounts them with podman
+type podmanProvider struct {
+	r *utils.Runner
+}
+
+// NewPodmanProvider returns an ImageProvider which uses podman
+func NewPodmanProvider(r *utils.Runner) ImageProvider {
+	return &podmanProvider{r: r}
+}
+
+// Pull pulls the image with podman, retrying on failure, and inspects it
+func (p *podmanProvider) Pull(image string) (types.ImageInspection, error) {
+	var imagedata types.ImageInspection
+	args := []string{"pull", "-q"}
+	if utils.FileExists(KubeletAuthFile) {
+		args = append(args, "--authfile", KubeletAuthFile)
+	}
+	args = append(args, image)
+	if _, err := p.r.RunExt(false, numRetriesNetCommands, "podman", args...); err != nil {
+		return imagedata, err
+	}
+
+	output, err := p.r.RunExt(true, 1, "podman", "inspect", "--type=image", image)
+	if err != nil {
+		return imagedata, err
+	}
+	var imagedataArray []types.ImageInspection
+	if err := json.Unmarshal([]byte(output), &imagedataArray); err != nil {
+		return imagedata, fmt.Errorf("failed to parse `podman inspect` output: %v", err)
+	}
+	if len(imagedataArray) == 0 {
+		return imagedata, fmt.Errorf("no image data returned by `podman inspect` for %s", image)
+	}
+	return imagedataArray[0], nil
+}
+
+// Mount creates a container from the image, without running it, and mounts it
+func (p *podmanProvider) Mount(image string) (string, func(), error) {
+	// Clean up any previous container which used the old name
+	podmanRemove(p.r, types.OldPivotName)
+
+	containerName := types.PivotNamePrefix + string(uuid.NewUUID())
+
+	// `podman mount` wants a container, so let's make create a dummy one, but not run it
+	cid, err := p.r.RunGetOut("podman", "create", "--net=none", "--annotation=org.openshift.machineconfigoperator.pivot=true", "--name", containerName, image)
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		// Kill our dummy container
+		podmanRemove(p.r, containerName)
+	}
+	// Use the container ID to find its mount point
+	mnt, err := p.r.RunGetOut("podman", "mount", cid)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return mnt, cleanup, nil
+}
+
+// Remove deletes the image from container storage
+func (p *podmanProvider) Remove(image string) {
+	// Related: https://github.com/containers/libpod/issues/2234
+	p.r.RunIgnoreErr("podman", "rmi", image)
+}
+
+// podmanRemove kills and removes a container
+func podmanRemove(r *utils.Runner, cid string) {
+	r.RunIgnoreErr("podman", "kill", cid)
+	r.RunIgnoreErr("podman", "rm", "-f", cid)
+}
+
+// registryProvider fetches images directly from the registry and extracts
+// them into temporary directories, leaving nothing behind once released
+type registryProvider struct {
+	reg    *registry.Client
+	tmpDir string
+	images map[string]registry.Image
+}
+
+// NewRegistryProvider returns an ImageProvider which does not need podman.
+// Images are extracted below tmpDir, or ImageExtractDir if it is empty.
+func NewRegistryProvider(reg *registry.Client, tmpDir string) ImageProvider {
+	if tmpDir == "" {
+		tmpDir = ImageExtractDir
+	}
+	return &registryProvider{
+		reg:    reg,
+		tmpDir: tmpDir,
+		images: map[string]registry.Image{},
+	}
+}
+
+// Pull fetches the image manifest and configuration. Layers are only
+// downloaded when the image is mounted.
+func (p *registryProvider) Pull(image string) (types.ImageInspection, error) {
+	img, err := p.reg.GetImage(image)
+	if err != nil {
+		return types.ImageInspection{}, err
+	}
+	// Mount may be given either the reference pulled or, for manifest
+	// lists, the platform image it resolved to
+	p.images[image] = img
+	p.images[img.Ref.String()] = img
+	return img.Inspection, nil
+}
+
+// Mount downloads and extracts the image layers into a temporary directory
+func (p *registryProvider) Mount(image string) (string, func(), error) {
+	img, ok := p.images[image]
+	if !ok {
+		return "", nil, fmt.Errorf("%s has not been pulled", image)
+	}
+	root, err := ioutil.TempDir(p.tmpDir, "pivot-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(root); err != nil {
+			glog.Warningf("Unable to remove %s: %v", root, err)
+		}
+	}
+	if err := p.reg.Unpack(img, root); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return root, cleanup, nil
+}
+
+// Remove forgets the image as nothing is kept after it is released
+func (p *registryProvider) Remove(image string) {
+	if img, ok := p.images[image]; ok {
+		delete(p.images, img.Ref.String())
+	}
+	delete(p.images, image)
+}
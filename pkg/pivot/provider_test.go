@@ -0,0 +1,99 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/openshift/pivot/pkg/registry/registrytest"
+	"github.com/openshift/pivot/utils"
+)
+
+func TestRegistryProvider(t *testing.T) {
+	server, reg := newTestRegistry()
+	defer server.Close()
+	config := registrytest.Config{Architecture: "amd64", OS: "linux"}
+	config.Config.Labels = map[string]string{"com.coreos.ostree-commit": "abcd"}
+	dgst := server.AddImage("os", "latest", config,
+		registrytest.Layer(
+			registrytest.File{Name: "srv/repo/config", Content: "[core]\n"},
+			registrytest.File{Name: "srv/repo/objects/old.commit", Content: "old"}),
+		registrytest.Layer(
+			registrytest.File{Name: "srv/repo/objects/.wh.old.commit"},
+			registrytest.File{Name: "srv/repo/objects/new.commit", Content: "new"}))
+	imageID := "registry.example.com/os@" + dgst.String()
+
+	tmpdir, err := ioutil.TempDir("", "provider")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// Rebase without podman or container storage
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{}]}`})
+	res, err := pullAndRebase(Options{
+		Image:    "registry.example.com/os:latest",
+		Runner:   r,
+		Registry: reg,
+		Provider: NewRegistryProvider(reg, tmpdir),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !res.Rebased || res.ImageID != imageID || res.Commit != "abcd" {
+		t.Fatalf("Expected a rebase to %s, got %+v", imageID, res)
+	}
+	if fake.Called("podman") || len(fake.Calls) != 2 {
+		t.Fatalf("Expected only rpm-ostree to be called, got %v", fake.Calls)
+	}
+	if entries, _ := ioutil.ReadDir(tmpdir); len(entries) != 0 {
+		t.Fatalf("Expected the extracted image to be removed, got %d entries", len(entries))
+	}
+
+	// Layers are applied in order with whiteouts
+	provider := NewRegistryProvider(reg, tmpdir)
+	inspection, err := provider.Pull(imageID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if inspection.Architecture != "amd64" || inspection.RepoDigests[0] != imageID {
+		t.Fatalf("Unexpected inspection %+v", inspection)
+	}
+	root, release, err := provider.Mount(imageID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	objects := filepath.Join(root, "srv/repo/objects")
+	if _, err := os.Stat(filepath.Join(objects, "old.commit")); !os.IsNotExist(err) {
+		t.Errorf("Expected old.commit to be whited out, got %v", err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(objects, "new.commit")); err != nil || string(content) != "new" {
+		t.Errorf("Expected new.commit, got %q %v", content, err)
+	}
+	release()
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed, got %v", root, err)
+	}
+	if _, _, err := provider.Mount("registry.example.com/os:other"); err == nil {
+		t.Fatalf("Expected an error mounting an image which was not pulled")
+	}
+
+	// The image for the platform is used from a manifest list
+	listDigest := server.AddManifestList("os", "multi", map[registrytest.Platform]digest.Digest{
+		{Architecture: "amd64", OS: "linux"}: dgst,
+		{Architecture: "arm64", OS: "linux"}: server.AddImage("os", "", registrytest.Config{Architecture: "arm64", OS: "linux"}),
+	})
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{}]}`})
+	listID := "registry.example.com/os@" + listDigest.String()
+	res, err = pullAndRebase(Options{Image: listID, Runner: r, Registry: reg, Provider: NewRegistryProvider(reg, tmpdir)})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !res.Rebased || res.ImageID != listID || res.Commit != "abcd" {
+		t.Fatalf("Expected a rebase to %s, got %+v", listID, res)
+	}
+}
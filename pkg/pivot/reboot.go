@@ -0,0 +1,296 @@
+package pivot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/utils"
+)
+
+// The modes of a RebootPolicy
+const (
+	// RebootNever leaves rebooting to someone else
+	RebootNever = "never"
+	// RebootImmediate reboots straight away
+	RebootImmediate = "immediate"
+	// RebootDelayed reboots after a delay using a transient systemd timer
+	RebootDelayed = "delayed"
+	// RebootMaintenance reboots in the next maintenance window using a transient systemd timer
+	RebootMaintenance = "maintenance"
+	// RebootMarker writes RebootRequiredFile for an external coordinator to reboot
+	RebootMarker = "marker"
+)
+
+// rebootTimerUnit is the transient unit which reboots later
+const rebootTimerUnit = "pivot-reboot"
+
+// MaintenanceWindow is a time of day, on some days of the week, when
+// rebooting is allowed
+type MaintenanceWindow struct {
+	Days   []time.Weekday // The days the window opens on, every day if empty
+	Start  time.Duration  // When the window opens, from local midnight
+	Length time.Duration  // How long the window is open
+}
+
+// String formats the window as it is parsed
+func (w MaintenanceWindow) String() string {
+	days := []string{}
+	for _, day := range w.Days {
+		days = append(days, day.String()[:3])
+	}
+	clock := func(d time.Duration) string {
+		d = d % (24 * time.Hour)
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	s := clock(w.Start) + "-" + clock(w.Start+w.Length)
+	if len(days) > 0 {
+		s = strings.Join(days, ",") + " " + s
+	}
+	return s
+}
+
+// opensOn checks if the window opens on day
+func (w MaintenanceWindow) opensOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns now if the window is open, or else when it next opens
+func (w MaintenanceWindow) Next(now time.Time) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// Start from yesterday as its window may run past midnight
+	for offset := -1; offset <= 7; offset++ {
+		day := midnight.AddDate(0, 0, offset)
+		if !w.opensOn(day.Weekday()) {
+			continue
+		}
+		start := day.Add(w.Start)
+		if !now.Before(start) && now.Before(start.Add(w.Length)) {
+			return now
+		}
+		if start.After(now) {
+			return start
+		}
+	}
+	// Unreachable, as every window opens within a week
+	return now
+}
+
+// RebootPolicy says how to reboot once a change needs one
+type RebootPolicy struct {
+	Mode   string             // One of the Reboot* modes
+	Delay  time.Duration      // How long RebootDelayed waits
+	Window *MaintenanceWindow // When RebootMaintenance reboots
+}
+
+// String formats the policy as it is parsed
+func (p RebootPolicy) String() string {
+	switch p.Mode {
+	case RebootDelayed:
+		return fmt.Sprintf("%s:%d", p.Mode, int(p.Delay.Minutes()))
+	case RebootMaintenance:
+		return fmt.Sprintf("%s:%s", p.Mode, p.Window)
+	}
+	return p.Mode
+}
+
+// parseClock parses a HH:MM time of day
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseMaintenanceWindow parses "[DAY,...] HH:MM-HH:MM", such as
+// "Sat,Sun 02:00-04:00". A window ending before it starts ends the next day.
+func parseMaintenanceWindow(s string) (*MaintenanceWindow, error) {
+	var w MaintenanceWindow
+	fields := strings.Fields(s)
+	if len(fields) == 2 {
+		for _, name := range strings.Split(fields[0], ",") {
+			found := false
+			for day := time.Sunday; day <= time.Saturday; day++ {
+				if strings.EqualFold(name, day.String()[:3]) {
+					w.Days = append(w.Days, day)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("invalid day %q, expected one of Mon to Sun", name)
+			}
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected [DAY,...] HH:MM-HH:MM", s)
+	}
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected [DAY,...] HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(times[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(times[1])
+	if err != nil {
+		return nil, err
+	}
+	w.Start = start
+	w.Length = end - start
+	if w.Length <= 0 {
+		w.Length += 24 * time.Hour
+	}
+	return &w, nil
+}
+
+// ParseRebootPolicy parses a policy: never, immediate, delayed:MINUTES,
+// maintenance:[DAY,...] HH:MM-HH:MM or marker
+func ParseRebootPolicy(s string) (RebootPolicy, error) {
+	mode := strings.TrimSpace(s)
+	arg := ""
+	if i := strings.Index(mode, ":"); i >= 0 {
+		mode, arg = mode[:i], strings.TrimSpace(mode[i+1:])
+	}
+	policy := RebootPolicy{Mode: mode}
+	switch mode {
+	case RebootNever, RebootImmediate, RebootMarker:
+		if arg != "" {
+			return policy, fmt.Errorf("reboot policy %s takes no argument", mode)
+		}
+	case RebootDelayed:
+		minutes, err := strconv.Atoi(arg)
+		if err != nil || minutes <= 0 {
+			return policy, fmt.Errorf("invalid delay %q, expected delayed:MINUTES", arg)
+		}
+		policy.Delay = time.Duration(minutes) * time.Minute
+	case RebootMaintenance:
+		window, err := parseMaintenanceWindow(arg)
+		if err != nil {
+			return policy, err
+		}
+		policy.Window = window
+	default:
+		return policy, fmt.Errorf("unknown reboot policy %q, expected one of never, immediate, delayed, maintenance or marker", s)
+	}
+	return policy, nil
+}
+
+// ReadRebootPolicy parses the policy in the file at path, which is nil if
+// the file does not exist or is empty
+func ReadRebootPolicy(path string) (*RebootPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil
+	}
+	policy, err := ParseRebootPolicy(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &policy, nil
+}
+
+// RebootRequired is written to RebootRequiredFile by the RebootMarker policy
+type RebootRequired struct {
+	Time time.Time `json:"time"` // When the reboot was found to be needed
+}
+
+// RebootOptions configures a call to Reboot
+type RebootOptions struct {
+	Policy       RebootPolicy     // How to reboot
+	Runner       *utils.Runner    // Runs external commands, defaults to the host
+	HooksDir     string           // The pre-reboot hooks, defaults to HooksDir
+	RequiredFile string           // Written by RebootMarker, defaults to RebootRequiredFile
+	Command      []string         // What the timer runs to reboot later, defaults to "pivot reboot"
+	Now          func() time.Time // The current time, defaults to time.Now
+}
+
+// Reboot reboots the system according to opts.Policy. An immediate reboot
+// first runs the pre-reboot hooks, and a later one runs opts.Command from
+// a transient systemd timer, replacing any reboot already scheduled.
+// Errors are of type *Error.
+func Reboot(opts RebootOptions) error {
+	r := opts.Runner
+	if r == nil {
+		r = utils.NewRunner(nil)
+	}
+	if opts.RequiredFile == "" {
+		opts.RequiredFile = RebootRequiredFile
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Command == nil {
+		exe, err := os.Executable()
+		if err != nil {
+			return newError(ErrReboot, err)
+		}
+		opts.Command = []string{exe, "reboot"}
+	}
+
+	var at time.Time
+	switch opts.Policy.Mode {
+	case RebootNever:
+		glog.Info("Not rebooting as the reboot policy is never")
+		return nil
+	case RebootImmediate:
+	case RebootDelayed:
+		at = opts.Now().Add(opts.Policy.Delay)
+	case RebootMaintenance:
+		now := opts.Now()
+		if next := opts.Policy.Window.Next(now); next.After(now) {
+			at = next
+		}
+	case RebootMarker:
+		glog.Infof("Recording that a reboot is required in %s", opts.RequiredFile)
+		if err := writeJSONFile(opts.RequiredFile, RebootRequired{Time: opts.Now().UTC()}); err != nil {
+			return newError(ErrReboot, err)
+		}
+		return nil
+	default:
+		return newError(ErrReboot, fmt.Errorf("unknown reboot policy %q", opts.Policy.Mode))
+	}
+
+	if !at.IsZero() {
+		glog.Infof("Scheduling a reboot at %s", at.Format(time.RFC3339))
+		r.RunIgnoreErr("systemctl", "stop", rebootTimerUnit+".timer")
+		args := []string{
+			"--unit=" + rebootTimerUnit,
+			"--description=Reboot scheduled by pivot",
+			"--on-calendar=" + at.UTC().Format("2006-01-02 15:04:05") + " UTC",
+			"--timer-property=AccuracySec=1s",
+		}
+		if err := r.Run("systemd-run", append(args, opts.Command...)...); err != nil {
+			return newError(ErrReboot, err)
+		}
+		return nil
+	}
+
+	if _, err := RunPreRebootHooks(Options{Runner: r, HooksDir: opts.HooksDir}); err != nil {
+		return err
+	}
+	if err := r.Run("systemctl", "reboot"); err != nil {
+		return newError(ErrReboot, err)
+	}
+	return nil
+}
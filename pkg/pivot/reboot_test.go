@@ -0,0 +1,157 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openshift/pivot/utils"
+)
+
+func TestParseRebootPolicy(t *testing.T) {
+	for _, s := range []string{
+		"never",
+		"immediate",
+		"delayed:30",
+		"maintenance:02:00-04:00",
+		"maintenance:Sat,Sun 23:00-01:00",
+		"marker",
+	} {
+		policy, err := ParseRebootPolicy(s + "\n")
+		if err != nil {
+			t.Fatalf("Expected %q to parse, got %v", s, err)
+		}
+		if policy.String() != s {
+			t.Fatalf("Expected %s, got %s", s, policy)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"sometimes",
+		"never:5",
+		"delayed",
+		"delayed:0",
+		"delayed:soon",
+		"maintenance:",
+		"maintenance:Someday 02:00-04:00",
+		"maintenance:02:00",
+		"maintenance:25:00-26:00",
+	} {
+		if _, err := ParseRebootPolicy(s); err == nil {
+			t.Fatalf("Expected %q to be invalid", s)
+		}
+	}
+
+	policy, _ := ParseRebootPolicy("maintenance:sat 23:00-01:00")
+	if len(policy.Window.Days) != 1 || policy.Window.Days[0] != time.Saturday || policy.Window.Length != 2*time.Hour {
+		t.Fatalf("Expected a 2 hour window on Saturday, got %+v", policy.Window)
+	}
+}
+
+func TestMaintenanceWindowNext(t *testing.T) {
+	// 2026-10-17 is a Saturday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, test := range []struct {
+		window   string
+		now      time.Time
+		expected time.Time
+	}{
+		{"02:00-04:00", at(17, 1, 0), at(17, 2, 0)},
+		{"02:00-04:00", at(17, 3, 0), at(17, 3, 0)},
+		{"02:00-04:00", at(17, 4, 0), at(18, 2, 0)},
+		{"Sat 23:00-01:00", at(18, 0, 30), at(18, 0, 30)},
+		{"Sat 23:00-01:00", at(18, 1, 0), at(24, 23, 0)},
+		{"Mon,Wed 02:00-04:00", at(17, 3, 0), at(19, 2, 0)},
+	} {
+		policy, err := ParseRebootPolicy("maintenance:" + test.window)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if next := policy.Window.Next(test.now); !next.Equal(test.expected) {
+			t.Fatalf("Expected %s to next open at %s from %s, got %s", test.window, test.expected, test.now, next)
+		}
+	}
+}
+
+func TestReboot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reboot")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	opts := RebootOptions{
+		HooksDir:     dir,
+		RequiredFile: filepath.Join(dir, "reboot-required"),
+		Command:      []string{"/usr/bin/pivot", "reboot"},
+		Now:          func() time.Time { return now },
+	}
+	reboot := func(policy string) *utils.FakeExecutor {
+		r, fake := newFakeRunner()
+		fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "abcd", "booted": true}]}`})
+		opts.Runner = r
+		if opts.Policy, err = ParseRebootPolicy(policy); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := Reboot(opts); err != nil {
+			t.Fatalf("Expected no error for %s, got %v", policy, err)
+		}
+		return fake
+	}
+
+	if fake := reboot("never"); len(fake.Calls) != 0 {
+		t.Fatalf("Expected nothing to be run, got %v", fake.Calls)
+	}
+	if fake := reboot("immediate"); !fake.Called("systemctl reboot") {
+		t.Fatalf("Expected a reboot, got %v", fake.Calls)
+	}
+
+	fake := reboot("delayed:30")
+	expected := "systemd-run --unit=pivot-reboot --description=Reboot scheduled by pivot --on-calendar=2026-10-17 12:30:00 UTC --timer-property=AccuracySec=1s /usr/bin/pivot reboot"
+	if !fake.Called(expected) || fake.Called("systemctl reboot") {
+		t.Fatalf("Expected %s, got %v", expected, fake.Calls)
+	}
+	if !fake.Called("systemctl stop pivot-reboot.timer") {
+		t.Fatalf("Expected an earlier reboot to be replaced, got %v", fake.Calls)
+	}
+
+	if fake := reboot("maintenance:Sun 02:00-04:00"); !fake.Called("systemd-run --unit=pivot-reboot --description=Reboot scheduled by pivot --on-calendar=2026-10-18 02:00:00 UTC") {
+		t.Fatalf("Expected a reboot in the next window, got %v", fake.Calls)
+	}
+	if fake := reboot("maintenance:11:00-13:00"); !fake.Called("systemctl reboot") || fake.Called("systemd-run") {
+		t.Fatalf("Expected a reboot within the window, got %v", fake.Calls)
+	}
+
+	if fake := reboot("marker"); len(fake.Calls) != 0 {
+		t.Fatalf("Expected nothing to be run, got %v", fake.Calls)
+	}
+	var required RebootRequired
+	if err := readJSONFile(opts.RequiredFile, &required); err != nil || !required.Time.Equal(now) {
+		t.Fatalf("Expected the reboot to be recorded at %s, got %+v %v", now, required, err)
+	}
+
+	// A failed pre-reboot hook stops the reboot
+	if err := os.MkdirAll(filepath.Join(dir, HookPreReboot), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, HookPreReboot, "drain"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "abcd", "booted": true}]}`}).
+		On(filepath.Join(dir, HookPreReboot, "drain"), utils.FakeResponse{ExitCode: 1})
+	opts.Runner = r
+	opts.Policy = RebootPolicy{Mode: RebootImmediate}
+	err = Reboot(opts)
+	if pivotErr, ok := err.(*Error); !ok || !pivotErr.Is(ErrHook) {
+		t.Fatalf("Expected ErrHook, got %v", err)
+	}
+	if fake.Called("systemctl reboot") {
+		t.Fatalf("Did not expect a reboot, got %v", fake.Calls)
+	}
+}
@@ -0,0 +1,155 @@
+package pivot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Outcomes of processing a Request
+const (
+	// RequestSucceeded is recorded when Pivot succeeded for the request
+	RequestSucceeded = "succeeded"
+	// RequestFailed is recorded when the request could not be read or Pivot failed
+	RequestFailed = "failed"
+)
+
+// Request is a queued request for Pivot, read from a *.json file in RequestsDir
+type Request struct {
+	Image      string   `json:"image,omitempty"`      // The oscontainer pullspec to pivot to, empty to only tune kernel arguments
+	KernelArgs []string `json:"kernelArgs,omitempty"` // Kernel tuning file lines to apply, such as "ADD nosmt"
+	Reboot     *bool    `json:"reboot,omitempty"`     // If to reboot after a change, unset to use the default
+}
+
+// RequestResult is written to ResultsDir for each processed Request
+type RequestResult struct {
+	Request  string    `json:"request"`          // The file name of the request
+	Outcome  string    `json:"outcome"`          // RequestSucceeded or RequestFailed
+	Error    string    `json:"error,omitempty"`  // Why the request failed
+	Reboot   bool      `json:"reboot"`           // If a reboot was requested after a change
+	Result   *Result   `json:"result,omitempty"` // What Pivot did, if it ran
+	Started  time.Time `json:"started"`          // When processing started
+	Finished time.Time `json:"finished"`         // When processing finished
+}
+
+// RequestOptions configures a call to ProcessRequests
+type RequestOptions struct {
+	Pivot       Options // The options for Pivot, except Image and KernelArgs which come from each request
+	RequestsDir string  // Where requests are queued, defaults to RequestsDir
+	ResultsDir  string  // Where results are written, defaults to ResultsDir
+	Reboot      bool    // If to reboot for requests which do not say
+}
+
+// ListRequests returns the paths of the requests queued in dir, in the
+// order they are processed. A missing dir has no requests.
+func ListRequests(dir string) ([]string, error) {
+	if dir == "" {
+		dir = RequestsDir
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// readRequest parses the request at path, rejecting unknown fields so
+// typos are not silently ignored
+func readRequest(path string) (Request, error) {
+	var req Request
+	f, err := os.Open(path)
+	if err != nil {
+		return req, err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if req.Image == "" && len(req.KernelArgs) == 0 {
+		return req, fmt.Errorf("%s has neither an image nor kernel arguments", path)
+	}
+	return req, nil
+}
+
+// processRequest runs Pivot for the request at path
+func processRequest(ctx context.Context, opts RequestOptions, path string) (RequestResult, error) {
+	result := RequestResult{Request: filepath.Base(path), Started: time.Now().UTC()}
+	req, err := readRequest(path)
+	if err == nil {
+		result.Reboot = opts.Reboot
+		if req.Reboot != nil {
+			result.Reboot = *req.Reboot
+		}
+		pivotOpts := opts.Pivot
+		pivotOpts.Image = req.Image
+		pivotOpts.KernelArgs = req.KernelArgs
+		var res Result
+		res, err = Pivot(ctx, pivotOpts)
+		result.Result = &res
+	}
+	result.Outcome = RequestSucceeded
+	if err != nil {
+		result.Outcome = RequestFailed
+		result.Error = err.Error()
+	}
+	result.Finished = time.Now().UTC()
+	return result, err
+}
+
+// ProcessRequests runs Pivot for each request queued in opts.RequestsDir,
+// in file name order. Each processed request is removed and a RequestResult
+// of the same name is written to opts.ResultsDir. Processing stops at the
+// first request which fails, leaving the later ones queued, and its error
+// is returned. With opts.Pivot.DryRun requests are neither removed nor
+// results written.
+func ProcessRequests(ctx context.Context, opts RequestOptions) ([]RequestResult, error) {
+	if opts.RequestsDir == "" {
+		opts.RequestsDir = RequestsDir
+	}
+	if opts.ResultsDir == "" {
+		opts.ResultsDir = ResultsDir
+	}
+	paths, err := ListRequests(opts.RequestsDir)
+	if err != nil {
+		return nil, err
+	}
+	results := []RequestResult{}
+	for _, path := range paths {
+		glog.Infof("Processing request %s", path)
+		result, err := processRequest(ctx, opts, path)
+		results = append(results, result)
+		if !opts.Pivot.DryRun {
+			if err := writeJSONFile(filepath.Join(opts.ResultsDir, result.Request), result); err != nil {
+				return results, err
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return results, err
+			}
+		}
+		if err != nil {
+			glog.Infof("Request %s failed; leaving later requests queued", path)
+			return results, err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,118 @@
+package pivot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+func TestProcessRequests(t *testing.T) {
+	server, reg := newTestRegistry()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "requests")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	requestsDir := filepath.Join(dir, "requests.d")
+	resultsDir := filepath.Join(dir, "results")
+	if err := os.MkdirAll(requestsDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for name, content := range map[string]string{
+		"10-image.json":  `{"image": "` + testDigestRef + `"}`,
+		"20-kargs.json":  `{"kernelArgs": ["ADD nosmt", "DELETE mitigations=off"], "reboot": false}`,
+		"30-typo.json":   `{"imgae": "registry.example.com/os:latest"}`,
+		"40-later.json":  `{"kernelArgs": ["ADD nosmt"]}`,
+		"README":         "not a request",
+		"cmdline":        "BOOT_IMAGE=/vmlinuz quiet mitigations=off",
+		"kernel-args.md": "not a request",
+	} {
+		path := filepath.Join(requestsDir, name)
+		if name == "cmdline" {
+			path = filepath.Join(dir, name)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "abcd", "custom-origin": ["pivot://` + testDigestRef + `", ""]}]}`})
+	results, err := ProcessRequests(context.Background(), RequestOptions{
+		Pivot: Options{
+			TuningFile:     filepath.Join(dir, "missing"),
+			KernelArgsFile: filepath.Join(dir, "missing.json"),
+			CmdLineFile:    filepath.Join(dir, "cmdline"),
+			Runner:         r,
+			Registry:       reg,
+		},
+		RequestsDir: requestsDir,
+		ResultsDir:  resultsDir,
+		Reboot:      true,
+	})
+	if err == nil {
+		t.Fatalf("Expected the request with a typo to fail")
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %+v", results)
+	}
+	if results[0].Outcome != RequestSucceeded || !results[0].Reboot || results[0].Result.Changed {
+		t.Fatalf("Expected the image request to succeed without a change, got %+v", results[0])
+	}
+	if results[1].Outcome != RequestSucceeded || results[1].Reboot || !results[1].Result.TuningChanged {
+		t.Fatalf("Expected the kernel argument request to succeed with a change, got %+v", results[1])
+	}
+	if !fake.Called("rpm-ostree kargs --delete=mitigations=off --append=nosmt") {
+		t.Fatalf("Expected the kernel arguments to be changed, got %v", fake.Calls)
+	}
+	if results[2].Outcome != RequestFailed || results[2].Error == "" || results[2].Result != nil {
+		t.Fatalf("Expected the request with a typo to fail, got %+v", results[2])
+	}
+
+	// Processed requests are removed, and the rest left queued
+	remaining, err := ListRequests(requestsDir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := []string{filepath.Join(requestsDir, "40-later.json")}; !reflect.DeepEqual(remaining, expected) {
+		t.Fatalf("Expected %v to remain, got %v", expected, remaining)
+	}
+	var result RequestResult
+	if err := readJSONFile(filepath.Join(resultsDir, "20-kargs.json"), &result); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expectedAdd := []types.TuneArgument{{Key: "nosmt", Bare: true}}
+	if result.Request != "20-kargs.json" || result.Outcome != RequestSucceeded || !reflect.DeepEqual(result.Result.KernelArgsAdded, expectedAdd) {
+		t.Fatalf("Expected the result to be written, got %+v", result)
+	}
+	if err := readJSONFile(filepath.Join(resultsDir, "30-typo.json"), &result); err != nil || result.Outcome != RequestFailed {
+		t.Fatalf("Expected the failure to be written, got %+v %v", result, err)
+	}
+
+	// A dry run changes nothing
+	r, fake = newFakeRunner()
+	results, err = ProcessRequests(context.Background(), RequestOptions{
+		Pivot: Options{
+			DryRun:         true,
+			TuningFile:     filepath.Join(dir, "missing"),
+			KernelArgsFile: filepath.Join(dir, "missing.json"),
+			CmdLineFile:    filepath.Join(dir, "cmdline"),
+			Runner:         r,
+		},
+		RequestsDir: requestsDir,
+		ResultsDir:  resultsDir,
+	})
+	if err != nil || len(results) != 1 || len(results[0].Result.KernelArgsAdded) != 1 {
+		t.Fatalf("Expected nosmt to be planned, got %+v %v", results, err)
+	}
+	if fake.Called("rpm-ostree kargs") || !utils.FileExists(filepath.Join(requestsDir, "40-later.json")) || utils.FileExists(filepath.Join(resultsDir, "40-later.json")) {
+		t.Fatalf("Expected a dry run to change nothing, got %v", fake.Calls)
+	}
+}
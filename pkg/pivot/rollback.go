@@ -0,0 +1,92 @@
+package pivot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+// RollbackOptions configures a call to Rollback
+type RollbackOptions struct {
+	Force      bool          // Roll back even if the target is not managed by pivot
+	RecordFile string        // Where the rollback is recorded, defaults to RollbackRecordFile
+	TargetFile string        // Where the deployment rolled back to is recorded for Verify, empty to not record it
+	Runner     *utils.Runner // Runs external commands, defaults to the host
+}
+
+// RollbackResult reports what Rollback did
+type RollbackResult struct {
+	Time time.Time         `json:"time"` // When the rollback was performed
+	From *DeploymentStatus `json:"from"` // The deployment which was the default
+	To   *DeploymentStatus `json:"to"`   // The deployment which is now the default
+}
+
+// rollbackTarget returns the current default deployment and the deployment
+// `rpm-ostree rollback` would make the default. With a pending deployment
+// the rollback target is the booted one, otherwise it is the one after it.
+func rollbackTarget(deployments []types.RpmOstreeDeployment) (types.RpmOstreeDeployment, types.RpmOstreeDeployment, error) {
+	if !deployments[0].Booted {
+		for _, deployment := range deployments[1:] {
+			if deployment.Booted {
+				return deployments[0], deployment, nil
+			}
+		}
+	} else if len(deployments) > 1 {
+		return deployments[0], deployments[1], nil
+	}
+	return types.RpmOstreeDeployment{}, types.RpmOstreeDeployment{}, fmt.Errorf("no rollback deployment found")
+}
+
+// Rollback makes the previous deployment the default, refusing to do so when
+// it is not managed by pivot unless opts.Force is set. A reboot is required
+// for the rollback to take effect. Errors are of type *Error.
+func Rollback(opts RollbackOptions) (RollbackResult, error) {
+	var res RollbackResult
+	r := opts.Runner
+	if r == nil {
+		r = utils.NewRunner(nil)
+	}
+	if opts.RecordFile == "" {
+		opts.RecordFile = RollbackRecordFile
+	}
+
+	deployments, err := getDeployments(r)
+	if err != nil {
+		return res, newError(ErrRollback, err)
+	}
+	from, to, err := rollbackTarget(deployments)
+	if err != nil {
+		return res, newError(ErrRollback, err)
+	}
+	res.From = newDeploymentStatus(from)
+	res.To = newDeploymentStatus(to)
+
+	if res.To.PivotImage == "" {
+		if !opts.Force {
+			return res, newError(ErrRollback, fmt.Errorf("rollback deployment %s is not managed by pivot", to.Checksum))
+		}
+		glog.Warningf("Rolling back to %s which is not managed by pivot", to.Checksum)
+	} else {
+		glog.Infof("Rolling back to: %s (%s)", res.To.PivotImage, to.Checksum)
+	}
+
+	if err := r.Run("rpm-ostree", "rollback"); err != nil {
+		return res, newError(ErrRollback, err)
+	}
+	res.Time = time.Now().UTC()
+
+	// Recording is best effort as the rollback itself already happened
+	if err := writeJSONFile(opts.RecordFile, res); err != nil {
+		glog.Warningf("Unable to record rollback in %s: %v", opts.RecordFile, err)
+	}
+	// Verify must not undo a rollback which was asked for
+	if opts.TargetFile != "" {
+		if err := writeJSONFile(opts.TargetFile, newVerifyTarget(res.To.PivotImage, res.To.baseCommit())); err != nil {
+			glog.Warningf("Unable to record the target in %s: %v", opts.TargetFile, err)
+		}
+	}
+	return res, nil
+}
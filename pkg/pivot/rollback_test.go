@@ -0,0 +1,61 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/openshift/pivot/utils"
+)
+
+func TestRollback(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rollback")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	recordFile := tmpdir + "/state/last-rollback.json"
+
+	// The booted deployment is the default so the next one is the target
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [
+		{"checksum": "new", "booted": true},
+		{"checksum": "old", "custom-origin": ["pivot://` + testDigestRef + `", ""]}]}`})
+	res, err := Rollback(RollbackOptions{RecordFile: recordFile, Runner: r})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if res.From.Checksum != "new" || res.To.Checksum != "old" || res.To.PivotImage != testDigestRef {
+		t.Fatalf("Expected a rollback from new to old, got %+v %+v", res.From, res.To)
+	}
+	if !fake.Called("rpm-ostree rollback") {
+		t.Fatalf("Expected a rollback, got %v", fake.Calls)
+	}
+	if _, err := os.Stat(recordFile); err != nil {
+		t.Fatalf("Expected the rollback to be recorded: %v", err)
+	}
+
+	// A pending deployment rolls back to the booted one, which is not managed
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [
+		{"checksum": "pending", "custom-origin": ["pivot://` + testDigestRef + `", ""]},
+		{"checksum": "booted", "booted": true}]}`})
+	_, err = Rollback(RollbackOptions{RecordFile: recordFile, Runner: r})
+	if pivotErr, ok := err.(*Error); !ok || pivotErr.Kind != ErrRollback {
+		t.Fatalf("Expected ErrRollback, got %v", err)
+	}
+	if fake.Called("rpm-ostree rollback") {
+		t.Fatalf("Did not expect a rollback, got %v", fake.Calls)
+	}
+	res, err = Rollback(RollbackOptions{Force: true, RecordFile: recordFile, Runner: r})
+	if err != nil || res.To.Checksum != "booted" {
+		t.Fatalf("Expected a forced rollback to booted, got %+v %v", res.To, err)
+	}
+
+	// Nothing to roll back to
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [{"checksum": "only", "booted": true}]}`})
+	if _, err = Rollback(RollbackOptions{Force: true, RecordFile: recordFile, Runner: r}); err == nil {
+		t.Fatalf("Expected an error without a rollback deployment")
+	}
+}
@@ -0,0 +1,95 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+// DeploymentStatus describes a single rpm-ostree deployment
+type DeploymentStatus struct {
+	ID           string `json:"id"`                     // The deployment identifier
+	Checksum     string `json:"checksum"`               // The OSTree commit of the deployment
+	BaseChecksum string `json:"baseChecksum,omitempty"` // The commit the deployment is layered on, if it has layered packages or overrides
+	Version      string `json:"version"`                // The OSTree version, if any
+	Staged       bool   `json:"staged"`                 // If the deployment is staged for the next boot
+	PivotImage   string `json:"pivotImage"`             // The image from the pivot:// origin, empty if not managed by pivot
+}
+
+// baseCommit returns the OSTree commit the deployment was created from,
+// before any local layering
+func (d *DeploymentStatus) baseCommit() string {
+	if d.BaseChecksum != "" {
+		return d.BaseChecksum
+	}
+	return d.Checksum
+}
+
+// Status reports the pivot related state of the system
+type Status struct {
+	Booted                    *DeploymentStatus       `json:"booted"`                    // The booted deployment
+	Pending                   *DeploymentStatus       `json:"pending"`                   // The deployment for the next boot, if not the booted one
+	PendingPullspec           string                  `json:"pendingPullspec"`           // The contents of EtcPivotFile, if any
+	PendingKernelArgsAdded    []types.TuneArgument    `json:"pendingKernelArgsAdded"`    // Arguments the tuning files would add
+	PendingKernelArgsDeleted  []types.TuneArgument    `json:"pendingKernelArgsDeleted"`  // Arguments the tuning files would delete
+	PendingKernelArgsReplaced []types.TuneReplacement `json:"pendingKernelArgsReplaced"` // Arguments the tuning files would give a new value
+	AppliedKernelTuning       *AppliedTuning          `json:"appliedKernelTuning"`       // The last tuning file applied, if any
+	RebootMarker              bool                    `json:"rebootMarker"`              // If RunPivotRebootFile exists
+	RebootRequired            bool                    `json:"rebootRequired"`            // If RebootRequiredFile exists
+}
+
+// newDeploymentStatus converts an rpm-ostree deployment to a DeploymentStatus
+func newDeploymentStatus(deployment types.RpmOstreeDeployment) *DeploymentStatus {
+	return &DeploymentStatus{
+		ID:           deployment.ID,
+		Checksum:     deployment.Checksum,
+		BaseChecksum: deployment.BaseChecksum,
+		Version:      deployment.Version,
+		Staged:       deployment.Staged,
+		PivotImage:   pivotImage(deployment),
+	}
+}
+
+// GetStatus gathers the Status of the system. Only the Runner, PullspecFile
+// and kernel argument fields of opts are used.
+func GetStatus(opts Options) (Status, error) {
+	var status Status
+	opts.complete()
+	r := opts.Runner
+
+	deployments, err := getDeployments(r)
+	if err != nil {
+		return status, err
+	}
+	for _, deployment := range deployments {
+		if deployment.Booted {
+			status.Booted = newDeploymentStatus(deployment)
+			break
+		}
+	}
+	if !deployments[0].Booted {
+		status.Pending = newDeploymentStatus(deployments[0])
+	}
+
+	data, err := ioutil.ReadFile(opts.PullspecFile)
+	if err != nil && !os.IsNotExist(err) {
+		return status, err
+	}
+	status.PendingPullspec = strings.TrimSpace(string(data))
+
+	plan, err := planTuning(&opts)
+	if err != nil {
+		return status, err
+	}
+	status.PendingKernelArgsAdded = plan.additions
+	status.PendingKernelArgsDeleted = plan.deletions
+	status.PendingKernelArgsReplaced = plan.replacements
+	status.AppliedKernelTuning = plan.applied
+
+	status.RebootMarker = utils.FileExists(RunPivotRebootFile)
+	status.RebootRequired = utils.FileExists(RebootRequiredFile)
+	return status, nil
+}
@@ -0,0 +1,58 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift/pivot/utils"
+)
+
+func TestGetStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"image-pullspec":   testDigestRef + "\n",
+		"kernel-args.json": `{"kernelArgs": ["nosmt"]}`,
+		"cmdline":          "BOOT_IMAGE=/vmlinuz quiet",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	r, fake := newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [
+		{"checksum": "new", "staged": true, "custom-origin": ["pivot://` + testDigestRef + `", ""]},
+		{"checksum": "old", "booted": true, "version": "41.1"}]}`})
+	status, err := GetStatus(Options{
+		TuningFile:          filepath.Join(dir, "kernel-args"),
+		KernelArgsFile:      filepath.Join(dir, "kernel-args.json"),
+		KernelArgsStateFile: filepath.Join(dir, "kernel-args-owned.json"),
+		TuningStateFile:     filepath.Join(dir, "kernel-args-applied.json"),
+		CmdLineFile:         filepath.Join(dir, "cmdline"),
+		AllowlistDirs:       []string{filepath.Join(dir, "kernel-args-allowlist.d")},
+		ProfileDirs:         []string{filepath.Join(dir, "profiles")},
+		PullspecFile:        filepath.Join(dir, "image-pullspec"),
+		Runner:              r,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if status.Booted == nil || status.Booted.Checksum != "old" || status.Booted.PivotImage != "" {
+		t.Fatalf("Expected the unmanaged booted deployment, got %+v", status.Booted)
+	}
+	if status.Pending == nil || !status.Pending.Staged || status.Pending.PivotImage != testDigestRef {
+		t.Fatalf("Expected the staged pivot deployment, got %+v", status.Pending)
+	}
+	if status.PendingPullspec != testDigestRef {
+		t.Fatalf("Expected the pending pullspec %s, got %s", testDigestRef, status.PendingPullspec)
+	}
+	if len(status.PendingKernelArgsAdded) != 1 || status.PendingKernelArgsAdded[0].Key != "nosmt" || status.AppliedKernelTuning != nil {
+		t.Fatalf("Expected nosmt to be added, got %+v", status)
+	}
+}
@@ -0,0 +1,193 @@
+package pivot
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+// isArgInUse checks to see if the argument is already in use by the system
+// currently. Arguments with a value are only in use if both match.
+func isArgInUse(arg, cmdLinePath string) (bool, error) {
+	if cmdLinePath == "" {
+		cmdLinePath = CmdLineFile
+	}
+	cmdline, err := ReadCmdLine(cmdLinePath)
+	if err != nil {
+		return false, err
+	}
+	return cmdline.Contains(types.ParseTuneArgument(arg)), nil
+}
+
+// logNotAllowed logs why a desired kernel argument is ignored
+func logNotAllowed(allowlist *Allowlist, arg types.TuneArgument) {
+	glog.Warningf("skipping %s", notAllowedReason(allowlist, arg))
+}
+
+// parseTuningFile parses the kernel argument tuning file against the kernel
+// command line at cmdLinePath
+func parseTuningFile(tuningFilePath, cmdLinePath string, allowlist *Allowlist) (tuningPlan, error) {
+	if cmdLinePath == "" {
+		cmdLinePath = CmdLineFile
+	}
+	return parseTuningArgs(tuningFilePath, func() (CmdLine, error) { return ReadCmdLine(cmdLinePath) }, allowlist, nil)
+}
+
+// containsReplacement checks if replacements has replacement
+func containsReplacement(replacements []types.TuneReplacement, replacement types.TuneReplacement) bool {
+	for _, r := range replacements {
+		if r == replacement {
+			return true
+		}
+	}
+	return false
+}
+
+// planReplace adds replacement to plan if the old value is in use
+func planReplace(plan *tuningPlan, replacement types.TuneReplacement, cmdline CmdLine) {
+	if containsReplacement(plan.replacements, replacement) {
+		return
+	}
+	if cmdline.Contains(replacement.Old()) {
+		plan.replacements = append(plan.replacements, replacement)
+	} else if cmdline.Contains(replacement.New()) {
+		glog.Infof(`skipping "%s" as it is already in use`, replacement.New())
+	} else {
+		glog.Infof(`skipping "%s" as it is not present in the current argument list`, replacement.Old())
+	}
+}
+
+// planSet adds the changes to plan which leave arg as the only value of
+// its key. A single other value is replaced, further values are deleted and
+// the argument is appended if the key is not in use.
+func planSet(plan *tuningPlan, arg types.TuneArgument, cmdline CmdLine) {
+	values := cmdline.Values(arg.Key)
+	if len(values) == 1 && values[0] == arg.Value {
+		glog.Infof(`skipping "%s" as it is already in use`, arg)
+		return
+	}
+	replaced := cmdline.Contains(arg)
+	for _, value := range values {
+		if value == arg.Value {
+			continue
+		}
+		if !replaced {
+			replacement := types.TuneReplacement{Key: arg.Key, OldValue: value, Value: arg.Value}
+			if !containsReplacement(plan.replacements, replacement) {
+				plan.replacements = append(plan.replacements, replacement)
+			}
+			replaced = true
+		} else {
+			plan.deletions = appendMissing(plan.deletions, types.TuneArgument{Key: arg.Key, Value: value})
+		}
+	}
+	if !replaced {
+		plan.additions = appendMissing(plan.additions, arg)
+	}
+}
+
+// parseTuningArgs parses the kernel argument tuning file. Lines which
+// cannot be applied, such as those with arguments allowlist does not allow,
+// are skipped with a warning. A nil allowlist uses the built in one, and
+// profiles are read from profileDirs.
+// The changes are relative to the kernel arguments returned by cmdLine,
+// which is only called if the tuning file exists.
+func parseTuningArgs(tuningFilePath string, cmdLine func() (CmdLine, error), allowlist *Allowlist, profileDirs []string) (tuningPlan, error) {
+	plan := newTuningPlan()
+	if tuningFilePath == "" {
+		tuningFilePath = KernelTuningFile
+	}
+	// Return fast if the file does not exist
+	if _, err := os.Stat(tuningFilePath); os.IsNotExist(err) {
+		glog.V(2).Infof("no kernel tuning needed as %s does not exist", tuningFilePath)
+		// This isn't an error. Return out.
+		return plan, err
+	}
+	cmdline, err := cmdLine()
+	if err != nil {
+		return plan, err
+	}
+	ops, problems, err := readTuningOps(tuningFilePath, allowlist, profileDirs)
+	if err != nil {
+		// If we have an issue reading return an error
+		glog.Infof("Unable to read %s: %v", tuningFilePath, err)
+		return plan, err
+	}
+	logTuningProblems(tuningFilePath, problems)
+	plan.addOps(ops, cmdline)
+	return plan, nil
+}
+
+// logTuningProblems warns about the lines from source which are skipped
+func logTuningProblems(source string, problems []TuningProblem) {
+	for _, p := range problems {
+		glog.Warningf(`skipping line %d of %s: "%s": %s`, p.Line, source, p.Text, p.Reason)
+	}
+}
+
+// addOps adds the changes ops make to cmdline to the plan
+func (plan *tuningPlan) addOps(ops []tuningOp, cmdline CmdLine) {
+	for _, op := range ops {
+		switch op.op {
+		case tuningAdd:
+			// Find out if the argument is in use
+			if !cmdline.Contains(op.arg) {
+				plan.additions = appendMissing(plan.additions, op.arg)
+			} else {
+				glog.Infof(`skipping "%s" as it is already in use`, op.arg)
+			}
+		case tuningDelete:
+			if cmdline.Contains(op.arg) {
+				plan.deletions = appendMissing(plan.deletions, op.arg)
+			} else {
+				glog.Infof(`skipping "%s" as it is not present in the current argument list`, op.arg)
+			}
+		case tuningReplace:
+			planReplace(plan, op.replacement, cmdline)
+		case tuningSet:
+			planSet(plan, op.arg, cmdline)
+		}
+	}
+}
+
+// updateTuningArgs executes the changes to kernel tuning arguments in the
+// tuning file
+func updateTuningArgs(r *utils.Runner, tuningFilePath, cmdLinePath string, allowlist *Allowlist) (bool, error) {
+	if cmdLinePath == "" {
+		cmdLinePath = CmdLineFile
+	}
+	plan, err := parseTuningFile(tuningFilePath, cmdLinePath, allowlist)
+	if err != nil {
+		return false, err
+	}
+	return applyTuningArgs(r, plan)
+}
+
+// applyTuningArgs executes the additions, removals and replacements of
+// kernel tuning arguments in plan. They are applied together in one
+// rpm-ostree transaction, so either all are applied in a single new
+// deployment or none are.
+func applyTuningArgs(r *utils.Runner, plan tuningPlan) (bool, error) {
+	if len(plan.additions) == 0 && len(plan.deletions) == 0 && len(plan.replacements) == 0 {
+		return false, nil
+	}
+	args := []string{"kargs"}
+	// Arguments with a value are deleted by key=value so only the matching
+	// instance of a repeated key is removed.
+	for _, toDelete := range plan.deletions {
+		args = append(args, fmt.Sprintf("--delete=%s", toDelete))
+	}
+	for _, toReplace := range plan.replacements {
+		args = append(args, fmt.Sprintf("--replace=%s", toReplace))
+	}
+	for _, toAdd := range plan.additions {
+		args = append(args, fmt.Sprintf("--append=%s", toAdd))
+	}
+	if err := r.Run("rpm-ostree", args...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,309 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/pivot/types"
+	"github.com/openshift/pivot/utils"
+)
+
+func TestParseTuningFile(t *testing.T) {
+	cmdLineFileMock, err := writeTestFile([]byte(
+		"BOOT_IMAGE=/a/vmlinuz.x86_64 resume=/dev/mapper/swap rhgb quiet root=/a/b/c/root ostree=/ostree/boot.0/a/0"))
+	defer os.Remove(cmdLineFileMock)
+
+	// Test with addition/deletion and verify white list
+	testFilePath, err := writeTestFile([]byte("ADD nosmt\nADD aaaa\nDELETE nosmt\nDELETE nope"))
+	defer os.Remove(testFilePath)
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	plan, err := parseTuningFile(testFilePath, cmdLineFileMock, nil)
+	if err != nil {
+		t.Fatalf(`Expected no error, got %s`, err)
+	}
+	if len(plan.additions) != 1 {
+		t.Fatalf("Expected 1 addition, got %v", len(plan.additions))
+	}
+
+	if len(plan.deletions) != 0 {
+		t.Fatalf("Expected 0 deletion, got %v", len(plan.deletions))
+	}
+
+	deleteCmdLineFileMockWith, err := writeTestFile([]byte(
+		"BOOT_IMAGE=/a/vmlinuz.x86_64 nosmt resume=/dev/mapper/swap rhgb quiet root=/a/b/c/root ostree=/ostree/boot.0/a/0"))
+	defer os.Remove(deleteCmdLineFileMockWith)
+
+	// Test with addition/deletion and verify white list
+	testFilePath, err = writeTestFile([]byte("ADD nosmt\nADD aaaa\nDELETE nosmt\nDELETE nope"))
+	defer os.Remove(testFilePath)
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	plan, err = parseTuningFile(testFilePath, deleteCmdLineFileMockWith, nil)
+	if err != nil {
+		t.Fatalf(`Expected no error, got %s`, err)
+	}
+	if len(plan.additions) != 0 {
+		t.Fatalf("Expected 1 addition, got %v", len(plan.additions))
+	}
+
+	if len(plan.deletions) != 1 {
+		t.Fatalf("Expected 1 deletion, got %v", len(plan.deletions))
+	}
+
+	// Test with no changes
+	testFilePath, err = writeTestFile([]byte(""))
+	defer os.Remove(testFilePath)
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	plan, err = parseTuningFile(testFilePath, cmdLineFileMock, nil)
+	if err != nil {
+		t.Fatalf(`Expected no error, got %s`, err)
+	}
+
+	if len(plan.additions) != 0 {
+		t.Fatalf("Expected 0 addition, got %v", len(plan.additions))
+	}
+
+	if len(plan.deletions) != 0 {
+		t.Fatalf("Expected 0 deletion, got %v", len(plan.additions))
+	}
+}
+
+func TestParseTuningFileValues(t *testing.T) {
+	cmdLineFileMock, err := writeTestFile([]byte("BOOT_IMAGE=/a/vmlinuz.x86_64 mitigations=off isolcpus=0-1 hugepages=16 quiet"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", cmdLineFileMock, err)
+	}
+	defer os.Remove(cmdLineFileMock)
+	testFilePath, err := writeTestFile([]byte(strings.Join([]string{
+		"ADD isolcpus=2-7",
+		"ADD hugepagesz=1G",
+		"ADD hugepages=16", // already in use
+		"ADD isolcpus=bad", // invalid value
+		"ADD nosmt=1",      // bare only
+		"ADD hugepagesz",   // value required
+		"DELETE mitigations=off",
+		"DELETE hugepages=32", // not in use with this value
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	defer os.Remove(testFilePath)
+
+	plan, err := parseTuningFile(testFilePath, cmdLineFileMock, nil)
+	if err != nil {
+		t.Fatalf(`Expected no error, got %s`, err)
+	}
+	expectedAdd := []types.TuneArgument{{Key: "isolcpus", Value: "2-7"}, {Key: "hugepagesz", Value: "1G"}}
+	if !reflect.DeepEqual(plan.additions, expectedAdd) {
+		t.Fatalf("Expected additions %v, got %v", expectedAdd, plan.additions)
+	}
+	expectedDelete := []types.TuneArgument{{Key: "mitigations", Value: "off"}}
+	if !reflect.DeepEqual(plan.deletions, expectedDelete) {
+		t.Fatalf("Expected deletions %v, got %v", expectedDelete, plan.deletions)
+	}
+
+	r, fake := newFakeRunner()
+	if changed, err := applyTuningArgs(r, plan); err != nil || !changed {
+		t.Fatalf("Expected a change and no error, got %v %v", changed, err)
+	}
+	expectedCalls := []string{
+		"rpm-ostree kargs --delete=mitigations=off --append=isolcpus=2-7 --append=hugepagesz=1G",
+	}
+	if !reflect.DeepEqual(fake.Calls, expectedCalls) {
+		t.Fatalf("Expected %v, got %v", expectedCalls, fake.Calls)
+	}
+
+	// Nothing to change runs nothing
+	r, fake = newFakeRunner()
+	if changed, err := applyTuningArgs(r, tuningPlan{}); err != nil || changed || len(fake.Calls) != 0 {
+		t.Fatalf("Expected no change, got %v %v %v", changed, err, fake.Calls)
+	}
+}
+
+func TestParseTuningFileReplace(t *testing.T) {
+	cmdLineFileMock, err := writeTestFile([]byte(
+		"BOOT_IMAGE=/a/vmlinuz.x86_64 mitigations=auto hugepagesz=2M hugepagesz=1G hugepages=16 quiet"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", cmdLineFileMock, err)
+	}
+	defer os.Remove(cmdLineFileMock)
+	testFilePath, err := writeTestFile([]byte(strings.Join([]string{
+		"REPLACE mitigations=auto=off",
+		"REPLACE hugepages=32=64",    // old value not in use
+		"REPLACE isolcpus=0-1=bad",   // new value not allowed
+		"REPLACE root=/dev/sda=/dev", // never changed
+		"REPLACE hugepages=16",       // malformed
+		"SET hugepages=32",
+		"SET default_hugepagesz=1G", // not in use so appended
+		"SET hugepagesz=1G",         // other values deleted
+		"SET nosmt",                 // malformed
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	defer os.Remove(testFilePath)
+
+	plan, err := parseTuningFile(testFilePath, cmdLineFileMock, nil)
+	if err != nil {
+		t.Fatalf(`Expected no error, got %s`, err)
+	}
+	expectedReplace := []types.TuneReplacement{
+		{Key: "mitigations", OldValue: "auto", Value: "off"},
+		{Key: "hugepages", OldValue: "16", Value: "32"},
+	}
+	if !reflect.DeepEqual(plan.replacements, expectedReplace) {
+		t.Fatalf("Expected replacements %v, got %v", expectedReplace, plan.replacements)
+	}
+	expectedAdd := []types.TuneArgument{{Key: "default_hugepagesz", Value: "1G"}}
+	if !reflect.DeepEqual(plan.additions, expectedAdd) {
+		t.Fatalf("Expected additions %v, got %v", expectedAdd, plan.additions)
+	}
+	expectedDelete := []types.TuneArgument{{Key: "hugepagesz", Value: "2M"}}
+	if !reflect.DeepEqual(plan.deletions, expectedDelete) {
+		t.Fatalf("Expected deletions %v, got %v", expectedDelete, plan.deletions)
+	}
+
+	r, fake := newFakeRunner()
+	if changed, err := applyTuningArgs(r, plan); err != nil || !changed {
+		t.Fatalf("Expected a change and no error, got %v %v", changed, err)
+	}
+	expectedCalls := []string{
+		"rpm-ostree kargs --delete=hugepagesz=2M --replace=mitigations=auto=off --replace=hugepages=16=32 --append=default_hugepagesz=1G",
+	}
+	if !reflect.DeepEqual(fake.Calls, expectedCalls) {
+		t.Fatalf("Expected %v, got %v", expectedCalls, fake.Calls)
+	}
+}
+
+func TestValidateTuningFile(t *testing.T) {
+	testFilePath, err := writeTestFile([]byte(strings.Join([]string{
+		"# Real-time isolation",
+		"ADD isolcpus=2-7",
+		"",
+		"  DELETE\tnosmt  ",
+		"add nosmt",
+		"ADD",
+		"ADD isolcpus=bad",
+		"DELETE root=/dev/sda",
+		"REPLACE mitigations=off",
+		"SET nosmt",
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	defer os.Remove(testFilePath)
+
+	err = ValidateTuningFile(testFilePath, nil)
+	tuningErr, ok := err.(*TuningFileError)
+	if !ok {
+		t.Fatalf("Expected a *TuningFileError, got %v", err)
+	}
+	expected := []TuningProblem{
+		{Line: 5, Text: "add nosmt", Reason: `unknown operation "add"`},
+		{Line: 6, Text: "ADD", Reason: "ADD requires an argument"},
+		{Line: 7, Text: "ADD isolcpus=bad", Reason: "isolcpus=bad is not an allowed kernel argument"},
+		{Line: 8, Text: "DELETE root=/dev/sda", Reason: "root=/dev/sda is never changed by pivot"},
+		{Line: 9, Text: "REPLACE mitigations=off", Reason: "REPLACE requires key=old=new"},
+		{Line: 10, Text: "SET nosmt", Reason: "SET requires key=value"},
+	}
+	if !reflect.DeepEqual(tuningErr.Problems, expected) {
+		t.Fatalf("Expected %v, got %v", expected, tuningErr.Problems)
+	}
+	if !strings.HasPrefix(err.Error(), testFilePath+":5: ") {
+		t.Fatalf("Expected the error to start with the file and line, got %v", err)
+	}
+
+	// Invalid lines are skipped when not strict
+	cmdLineFileMock, err := writeTestFile([]byte("BOOT_IMAGE=/a/vmlinuz.x86_64 nosmt quiet"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", cmdLineFileMock, err)
+	}
+	defer os.Remove(cmdLineFileMock)
+	plan, err := parseTuningFile(testFilePath, cmdLineFileMock, nil)
+	if err != nil {
+		t.Fatalf(`Expected no error, got %s`, err)
+	}
+	if len(plan.additions) != 1 || len(plan.deletions) != 1 {
+		t.Fatalf("Expected 1 addition and 1 deletion, got %v %v", plan.additions, plan.deletions)
+	}
+
+	// Valid files pass
+	if err := ioutil.WriteFile(testFilePath, []byte("# nothing but a comment\n\nADD nosmt\n"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ValidateTuningFile(testFilePath, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestIsArgInUse(t *testing.T) {
+	testFilePath, err := writeTestFile([]byte(
+		"BOOT_IMAGE=/a/vmlinuz.x86_64 resume=/dev/mapper/swap rhgb quiet root=/a/b/c/root ostree=/ostree/boot.0/a/0"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	defer os.Remove(testFilePath)
+
+	// Should be present
+	available, err := isArgInUse("quiet", testFilePath)
+	if err != nil {
+		t.Fatalf(`Expected no error, got %s`, err)
+	}
+	if available != true {
+		t.Fatalf("Expected true, got false")
+	}
+
+	// Only whole arguments match
+	for _, arg := range []string{"idonotexist", "quie", "resume", "resume=/dev/mapper", "rhgb=1"} {
+		available, err = isArgInUse(arg, testFilePath)
+		if err != nil {
+			t.Fatalf(`Expected no error, got %s`, err)
+		}
+		if available {
+			t.Fatalf("Expected %s not to be in use", arg)
+		}
+	}
+	available, err = isArgInUse("resume=/dev/mapper/swap", testFilePath)
+	if err != nil {
+		t.Fatalf(`Expected no error, got %s`, err)
+	}
+	if available != true {
+		t.Fatalf("Expected true, got false")
+	}
+}
+
+func TestUpdateTuningArgs(t *testing.T) {
+	cmdLineFileMock, err := writeTestFile([]byte("BOOT_IMAGE=/a/vmlinuz.x86_64 nosmt quiet"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", cmdLineFileMock, err)
+	}
+	defer os.Remove(cmdLineFileMock)
+	testFilePath, err := writeTestFile([]byte("DELETE nosmt"))
+	if err != nil {
+		t.Fatalf("unable to write test file %s: %s", testFilePath, err)
+	}
+	defer os.Remove(testFilePath)
+
+	r, fake := newFakeRunner()
+	changed, err := updateTuningArgs(r, testFilePath, cmdLineFileMock, nil)
+	if err != nil || !changed {
+		t.Fatalf("Expected a change and no error, got %v %v", changed, err)
+	}
+	if !fake.Called("rpm-ostree kargs --delete=nosmt") {
+		t.Fatalf("Expected nosmt to be deleted, got %v", fake.Calls)
+	}
+
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree kargs", utils.FakeResponse{ExitCode: 1})
+	if changed, err = updateTuningArgs(r, testFilePath, cmdLineFileMock, nil); err == nil || changed {
+		t.Fatalf("Expected an error and no change, got %v %v", changed, err)
+	}
+}
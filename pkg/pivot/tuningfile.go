@@ -0,0 +1,214 @@
+package pivot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/openshift/pivot/types"
+)
+
+// Operations of the kernel tuning file
+const (
+	tuningAdd     = "ADD"
+	tuningDelete  = "DELETE"
+	tuningReplace = "REPLACE"
+	tuningSet     = "SET"
+	tuningProfile = "PROFILE"
+)
+
+// TuningProblem is a line of a kernel tuning file which cannot be applied
+type TuningProblem struct {
+	Line   int    `json:"line"`   // The line number, starting at 1
+	Text   string `json:"text"`   // The text of the line
+	Reason string `json:"reason"` // Why the line cannot be applied
+}
+
+// TuningFileError lists the problems found in a kernel tuning file
+type TuningFileError struct {
+	File     string
+	Problems []TuningProblem
+}
+
+// Error implements the error interface
+func (e *TuningFileError) Error() string {
+	problems := []string{}
+	for _, p := range e.Problems {
+		problems = append(problems, fmt.Sprintf("%s:%d: %s", e.File, p.Line, p.Reason))
+	}
+	return strings.Join(problems, "; ")
+}
+
+// tuningOp is a single valid line of a kernel tuning file
+type tuningOp struct {
+	line        int                   // The line number
+	op          string                // One of tuningAdd, tuningDelete, tuningReplace or tuningSet
+	arg         types.TuneArgument    // The argument, unless op is tuningReplace
+	replacement types.TuneReplacement // The replacement if op is tuningReplace
+}
+
+// parseReplace parses the key=old=new argument of a REPLACE line
+func parseReplace(arg string) (types.TuneReplacement, bool) {
+	parts := strings.SplitN(arg, "=", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return types.TuneReplacement{}, false
+	}
+	return types.TuneReplacement{Key: parts[0], OldValue: parts[1], Value: parts[2]}, true
+}
+
+// notAllowedReason describes why allowlist does not allow arg
+func notAllowedReason(allowlist *Allowlist, arg types.TuneArgument) string {
+	if allowlist.Denied(arg) {
+		return fmt.Sprintf("%s is never changed by pivot", arg)
+	}
+	return fmt.Sprintf("%s is not an allowed kernel argument", arg)
+}
+
+// parseTuningLine parses a single non-empty, non-comment line, returning
+// why it is invalid if it is
+func parseTuningLine(line string, allowlist *Allowlist) (tuningOp, string) {
+	op := tuningOp{op: line}
+	value := ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		op.op = line[:i]
+		value = strings.TrimSpace(line[i:])
+	}
+	switch op.op {
+	case tuningAdd, tuningDelete, tuningSet:
+		if value == "" {
+			return op, fmt.Sprintf("%s requires an argument", op.op)
+		}
+		op.arg = types.ParseTuneArgument(value)
+		if op.op == tuningSet && op.arg.Bare {
+			return op, "SET requires key=value"
+		}
+		if !allowlist.Allowed(op.arg) {
+			return op, notAllowedReason(allowlist, op.arg)
+		}
+	case tuningReplace:
+		replacement, ok := parseReplace(value)
+		if !ok {
+			return op, "REPLACE requires key=old=new"
+		}
+		op.replacement = replacement
+		for _, arg := range []types.TuneArgument{replacement.Old(), replacement.New()} {
+			if !allowlist.Allowed(arg) {
+				return op, notAllowedReason(allowlist, arg)
+			}
+		}
+	default:
+		return op, fmt.Sprintf("unknown operation %q", op.op)
+	}
+	return op, ""
+}
+
+// parseProfileLine expands a PROFILE line into an ADD for each argument of
+// the profile, returning why those which cannot be added are invalid
+func parseProfileLine(line string, allowlist *Allowlist, profileDirs []string) ([]tuningOp, []string) {
+	name := strings.TrimSpace(strings.TrimPrefix(line, tuningProfile))
+	if name == "" {
+		return nil, []string{"PROFILE requires a name"}
+	}
+	args, err := LoadProfile(name, profileDirs...)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	ops := []tuningOp{}
+	reasons := []string{}
+	for _, arg := range args {
+		if !allowlist.Allowed(arg) {
+			reasons = append(reasons, fmt.Sprintf("profile %s: %s", name, notAllowedReason(allowlist, arg)))
+			continue
+		}
+		ops = append(ops, tuningOp{op: tuningAdd, arg: arg})
+	}
+	return ops, reasons
+}
+
+// readTuningOps reads the valid lines of the kernel tuning file at path
+// and the problems with the others. Blank lines and lines starting with #
+// are ignored, and PROFILE lines are expanded using the profiles in
+// profileDirs. A nil allowlist uses the built in one.
+func readTuningOps(path string, allowlist *Allowlist, profileDirs []string) ([]tuningOp, []TuningProblem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return parseTuningOps(file, allowlist, profileDirs)
+}
+
+// parseTuningOps reads the valid lines of kernel tuning from r and the
+// problems with the others, as readTuningOps does
+func parseTuningOps(r io.Reader, allowlist *Allowlist, profileDirs []string) ([]tuningOp, []TuningProblem, error) {
+	if allowlist == nil {
+		allowlist = defaultKernelArgsAllowlist()
+	}
+	ops := []tuningOp{}
+	problems := []TuningProblem{}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == tuningProfile || strings.HasPrefix(line, tuningProfile+" ") || strings.HasPrefix(line, tuningProfile+"\t") {
+			profileOps, reasons := parseProfileLine(line, allowlist, profileDirs)
+			for _, reason := range reasons {
+				problems = append(problems, TuningProblem{Line: lineNumber, Text: line, Reason: reason})
+			}
+			for _, op := range profileOps {
+				op.line = lineNumber
+				ops = append(ops, op)
+			}
+			continue
+		}
+		op, reason := parseTuningLine(line, allowlist)
+		if reason != "" {
+			problems = append(problems, TuningProblem{Line: lineNumber, Text: line, Reason: reason})
+			continue
+		}
+		op.line = lineNumber
+		ops = append(ops, op)
+	}
+	return ops, problems, scanner.Err()
+}
+
+// ValidateTuningFile checks every line of the kernel tuning file at path
+// can be applied, without looking at the kernel arguments in use. The
+// problems found are returned as a *TuningFileError. A nil allowlist uses
+// the built in one, and profiles are read from profileDirs.
+func ValidateTuningFile(path string, allowlist *Allowlist, profileDirs ...string) error {
+	_, problems, err := readTuningOps(path, allowlist, profileDirs)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return &TuningFileError{File: path, Problems: problems}
+	}
+	return nil
+}
+
+// kernelArgsSource names opts.KernelArgs in errors
+const kernelArgsSource = "kernel arguments"
+
+// validateTuning validates opts.TuningFile, if there is one, and
+// opts.KernelArgs against the allowlist
+func (opts *Options) validateTuning() error {
+	if err := opts.loadAllowlist(); err != nil {
+		return err
+	}
+	if err := ValidateTuningFile(opts.TuningFile, opts.Allowlist, opts.ProfileDirs...); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, problems, err := parseTuningOps(strings.NewReader(strings.Join(opts.KernelArgs, "\n")), opts.Allowlist, opts.ProfileDirs)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return &TuningFileError{File: kernelArgsSource, Problems: problems}
+	}
+	return nil
+}
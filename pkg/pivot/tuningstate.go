@@ -0,0 +1,72 @@
+package pivot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/openshift/pivot/types"
+)
+
+// Outcomes of applying the kernel tuning file
+const (
+	// TuningApplied is recorded when the changes were applied, or none were needed
+	TuningApplied = "applied"
+	// TuningFailed is recorded when applying the changes failed
+	TuningFailed = "failed"
+)
+
+// AppliedTuning records the last kernel tuning file pivot applied
+type AppliedTuning struct {
+	File     string                  `json:"file"`               // The path of the tuning file
+	Checksum string                  `json:"checksum"`           // The sha256 of the tuning file contents
+	Outcome  string                  `json:"outcome"`            // TuningApplied or TuningFailed
+	Error    string                  `json:"error,omitempty"`    // Why applying failed
+	Time     time.Time               `json:"time"`               // When the file was applied
+	Added    []types.TuneArgument    `json:"added,omitempty"`    // Kernel arguments appended
+	Deleted  []types.TuneArgument    `json:"deleted,omitempty"`  // Kernel arguments deleted
+	Replaced []types.TuneReplacement `json:"replaced,omitempty"` // Kernel arguments given a new value
+}
+
+// fileChecksum returns the hex encoded sha256 of the contents of path
+func fileChecksum(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readAppliedTuning reads the record at path, which is nil if nothing was
+// applied yet
+func readAppliedTuning(path string) (*AppliedTuning, error) {
+	var applied AppliedTuning
+	if err := readJSONFile(path, &applied); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &applied, nil
+}
+
+// newAppliedTuning records the outcome of applying plan
+func newAppliedTuning(file string, plan tuningPlan, err error) *AppliedTuning {
+	applied := &AppliedTuning{
+		File:     file,
+		Checksum: plan.tuningChecksum,
+		Outcome:  TuningApplied,
+		Time:     time.Now().UTC(),
+		Added:    plan.additions,
+		Deleted:  plan.deletions,
+		Replaced: plan.replacements,
+	}
+	if err != nil {
+		applied.Outcome = TuningFailed
+		applied.Error = err.Error()
+	}
+	return applied
+}
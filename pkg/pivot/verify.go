@@ -0,0 +1,188 @@
+package pivot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/utils"
+)
+
+// VerifyTarget is the deployment pivot expects the system to boot, recorded
+// in TargetFile after a rebase or rollback
+type VerifyTarget struct {
+	ImageID   string    `json:"imageID,omitempty"` // The image of the pivot:// origin, empty if not managed by pivot
+	Commit    string    `json:"commit"`            // The OSTree commit
+	Time      time.Time `json:"time"`              // When the target was recorded
+	Rollbacks int       `json:"rollbacks"`         // The rollbacks Verify made since pivot last rebased
+	Verified  bool      `json:"verified"`          // If the target was booted and healthy
+}
+
+// VerifyOptions configures a call to Verify
+type VerifyOptions struct {
+	TargetFile   string        // The recorded target, defaults to TargetFile
+	HealthDir    string        // The health check executables, defaults to HealthDir
+	RecordFile   string        // Where a rollback is recorded, defaults to RollbackRecordFile
+	MaxRollbacks int           // The rollbacks allowed before giving up, zero to never roll back
+	Runner       *utils.Runner // Runs external commands, defaults to the host
+}
+
+// VerifyResult reports what Verify found and did
+type VerifyResult struct {
+	Target     *VerifyTarget     `json:"target"`             // The target verified against, nil if there is none
+	Booted     *DeploymentStatus `json:"booted"`             // The booted deployment
+	Problems   []string          `json:"problems,omitempty"` // Why verification failed
+	Verified   bool              `json:"verified"`           // If the target was booted and healthy
+	RolledBack bool              `json:"rolledBack"`         // If a rollback was made, which needs a reboot
+	Rollback   *RollbackResult   `json:"rollback,omitempty"` // The rollback, if one was made
+}
+
+// newVerifyTarget returns a target for the deployment of image and commit
+func newVerifyTarget(image, commit string) *VerifyTarget {
+	return &VerifyTarget{ImageID: image, Commit: commit, Time: time.Now().UTC()}
+}
+
+// readVerifyTarget reads the target at path, which is nil if there is none
+func readVerifyTarget(path string) (*VerifyTarget, error) {
+	var target VerifyTarget
+	if err := readJSONFile(path, &target); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &target, nil
+}
+
+// listExecutables returns the executables in dir in name order. A missing dir
+// has none.
+func listExecutables(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	checks := []string{}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && entry.Mode()&0111 != 0 && !strings.HasPrefix(entry.Name(), ".") {
+			checks = append(checks, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return checks, nil
+}
+
+// checkBooted returns why the booted deployment is not the target. Local
+// package layering or overrides build a new commit on top of the target, so
+// the commit it was built from is compared.
+func checkBooted(target *VerifyTarget, booted *DeploymentStatus) []string {
+	if commit := booted.baseCommit(); commit != target.Commit {
+		return []string{fmt.Sprintf("booted commit %s, expected %s", commit, target.Commit)}
+	}
+	if target.ImageID == "" {
+		return nil
+	}
+	if booted.PivotImage == "" {
+		return []string{fmt.Sprintf("booted deployment is not managed by pivot, expected %s", target.ImageID)}
+	}
+	if matched, err := compareOSImageURL(booted.PivotImage, target.ImageID); err != nil || !matched {
+		return []string{fmt.Sprintf("booted image %s, expected %s", booted.PivotImage, target.ImageID)}
+	}
+	return nil
+}
+
+// Verify checks the system booted the deployment recorded in
+// opts.TargetFile and that the health checks in opts.HealthDir pass. If not,
+// the system is rolled back, unless opts.MaxRollbacks were already made
+// since pivot last rebased, in which case an error is returned. A reboot is
+// required if the result has RolledBack set. Errors are of type *Error.
+func Verify(opts VerifyOptions) (VerifyResult, error) {
+	var res VerifyResult
+	r := opts.Runner
+	if r == nil {
+		r = utils.NewRunner(nil)
+	}
+	if opts.TargetFile == "" {
+		opts.TargetFile = TargetFile
+	}
+	if opts.HealthDir == "" {
+		opts.HealthDir = HealthDir
+	}
+
+	target, err := readVerifyTarget(opts.TargetFile)
+	if err != nil {
+		return res, newError(ErrVerify, err)
+	}
+	res.Target = target
+	if target == nil {
+		glog.Infof("Nothing to verify as %s does not exist", opts.TargetFile)
+		return res, nil
+	}
+	if target.Verified {
+		glog.Infof("%s was already verified", target.Commit)
+		res.Verified = true
+		return res, nil
+	}
+
+	deployments, err := getDeployments(r)
+	if err != nil {
+		return res, newError(ErrVerify, err)
+	}
+	for _, deployment := range deployments {
+		if deployment.Booted {
+			res.Booted = newDeploymentStatus(deployment)
+		}
+	}
+	if res.Booted == nil {
+		return res, newError(ErrVerify, fmt.Errorf("no booted deployment"))
+	}
+
+	res.Problems = checkBooted(target, res.Booted)
+	if len(res.Problems) == 0 {
+		checks, err := listExecutables(opts.HealthDir)
+		if err != nil {
+			return res, newError(ErrVerify, err)
+		}
+		for _, check := range checks {
+			glog.Infof("Running health check %s", check)
+			if err := r.Run(check); err != nil {
+				res.Problems = append(res.Problems, fmt.Sprintf("health check %s failed: %v", filepath.Base(check), err))
+			}
+		}
+	}
+
+	if len(res.Problems) == 0 {
+		glog.Infof("Verified %s", target.Commit)
+		target.Verified = true
+		res.Verified = true
+		if err := writeJSONFile(opts.TargetFile, target); err != nil {
+			return res, newError(ErrVerify, err)
+		}
+		return res, nil
+	}
+
+	problems := strings.Join(res.Problems, "; ")
+	if target.Rollbacks >= opts.MaxRollbacks {
+		return res, newError(ErrVerify, fmt.Errorf("%s; not rolling back after %d rollbacks", problems, target.Rollbacks))
+	}
+	glog.Warningf("Verification failed: %s; rolling back", problems)
+	rollback, err := Rollback(RollbackOptions{Force: true, RecordFile: opts.RecordFile, Runner: r})
+	if err != nil {
+		return res, err
+	}
+	res.RolledBack = true
+	res.Rollback = &rollback
+
+	// The deployment rolled back to is verified next, counting the rollback
+	next := newVerifyTarget(rollback.To.PivotImage, rollback.To.baseCommit())
+	next.Rollbacks = target.Rollbacks + 1
+	if err := writeJSONFile(opts.TargetFile, next); err != nil {
+		return res, newError(ErrVerify, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,140 @@
+package pivot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift/pivot/utils"
+)
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	healthDir := filepath.Join(dir, "health.d")
+	if err := os.MkdirAll(healthDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for name, mode := range map[string]os.FileMode{"10-kubelet": 0755, "20-network": 0755, "README": 0644} {
+		if err := ioutil.WriteFile(filepath.Join(healthDir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	opts := VerifyOptions{
+		TargetFile:   filepath.Join(dir, "target.json"),
+		HealthDir:    healthDir,
+		RecordFile:   filepath.Join(dir, "last-rollback.json"),
+		MaxRollbacks: 1,
+	}
+	deployments := `{"deployments": [
+		{"checksum": "new", "booted": true, "custom-origin": ["pivot://` + testDigestRef + `", ""]},
+		{"checksum": "old", "custom-origin": ["pivot://registry.example.com/os@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff", ""]}]}`
+
+	// Nothing to verify without a target
+	r, fake := newFakeRunner()
+	opts.Runner = r
+	if res, err := Verify(opts); err != nil || res.Target != nil || len(fake.Calls) != 0 {
+		t.Fatalf("Expected nothing to be verified, got %+v %v %v", res, err, fake.Calls)
+	}
+
+	// The target is booted and healthy
+	if err := writeJSONFile(opts.TargetFile, newVerifyTarget(testDigestRef, "new")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: deployments})
+	opts.Runner = r
+	res, err := Verify(opts)
+	if err != nil || !res.Verified || res.RolledBack {
+		t.Fatalf("Expected the target to be verified, got %+v %v", res, err)
+	}
+	for _, check := range []string{"10-kubelet", "20-network"} {
+		if !fake.Called(filepath.Join(healthDir, check)) {
+			t.Fatalf("Expected %s to run, got %v", check, fake.Calls)
+		}
+	}
+	if fake.Called(filepath.Join(healthDir, "README")) {
+		t.Fatalf("Did not expect a non-executable to run, got %v", fake.Calls)
+	}
+	target, err := readVerifyTarget(opts.TargetFile)
+	if err != nil || !target.Verified {
+		t.Fatalf("Expected the target to be recorded as verified, got %+v %v", target, err)
+	}
+
+	// A failed health check rolls back, and the rollback target is verified next
+	if err := writeJSONFile(opts.TargetFile, newVerifyTarget(testDigestRef, "new")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: deployments}).
+		On(filepath.Join(healthDir, "20-network"), utils.FakeResponse{ExitCode: 1})
+	opts.Runner = r
+	res, err = Verify(opts)
+	if err != nil || res.Verified || !res.RolledBack || len(res.Problems) != 1 {
+		t.Fatalf("Expected a rollback, got %+v %v", res, err)
+	}
+	if !fake.Called("rpm-ostree rollback") {
+		t.Fatalf("Expected a rollback, got %v", fake.Calls)
+	}
+	target, err = readVerifyTarget(opts.TargetFile)
+	if err != nil || target.Commit != "old" || target.Rollbacks != 1 || target.Verified {
+		t.Fatalf("Expected old to be the target after 1 rollback, got %+v %v", target, err)
+	}
+
+	// Once the rollbacks are used up, failures are only reported
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: deployments})
+	opts.Runner = r
+	res, err = Verify(opts)
+	if pivotErr, ok := err.(*Error); !ok || !pivotErr.Is(ErrVerify) {
+		t.Fatalf("Expected ErrVerify, got %v", err)
+	}
+	if res.RolledBack || fake.Called("rpm-ostree rollback") || fake.Called(filepath.Join(healthDir, "10-kubelet")) {
+		t.Fatalf("Expected neither health checks nor a rollback for the wrong commit, got %v", fake.Calls)
+	}
+
+	// The image of the pivot:// origin must match too
+	if err := writeJSONFile(opts.TargetFile, newVerifyTarget("registry.example.com/os@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff", "new")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: deployments})
+	opts.Runner = r
+	if res, err = Verify(opts); err != nil || !res.RolledBack {
+		t.Fatalf("Expected a rollback for the wrong image, got %+v %v", res, err)
+	}
+
+	// Package layering boots a local commit built on the target
+	if err := writeJSONFile(opts.TargetFile, newVerifyTarget(testDigestRef, "new")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [
+		{"checksum": "local", "base-checksum": "new", "booted": true, "packages": ["strace"], "custom-origin": ["pivot://` + testDigestRef + `", ""]},
+		{"checksum": "old-local", "base-checksum": "old", "custom-origin": ["pivot://registry.example.com/os@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff", ""]}]}`})
+	opts.Runner = r
+	if res, err = Verify(opts); err != nil || !res.Verified || res.RolledBack {
+		t.Fatalf("Expected the layered target to be verified, got %+v %v", res, err)
+	}
+
+	// Rolling back to a layered deployment targets the commit it was built on
+	if err := writeJSONFile(opts.TargetFile, newVerifyTarget(testDigestRef, "new")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r, fake = newFakeRunner()
+	fake.On("rpm-ostree status", utils.FakeResponse{Output: `{"deployments": [
+		{"checksum": "local", "base-checksum": "new", "booted": true, "custom-origin": ["pivot://` + testDigestRef + `", ""]},
+		{"checksum": "old-local", "base-checksum": "old", "custom-origin": ["pivot://registry.example.com/os@sha256:2a76681fd15bfc06fa4aa0ff6913ba17527e075417fc92ea29f6bcc2afca24ff", ""]}]}`}).
+		On(filepath.Join(healthDir, "10-kubelet"), utils.FakeResponse{ExitCode: 1})
+	opts.Runner = r
+	if res, err = Verify(opts); err != nil || !res.RolledBack {
+		t.Fatalf("Expected a rollback, got %+v %v", res, err)
+	}
+	if target, err = readVerifyTarget(opts.TargetFile); err != nil || target.Commit != "old" {
+		t.Fatalf("Expected old to be the target, got %+v %v", target, err)
+	}
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// authConfig is the subset of a docker/podman auth file which is used
+type authConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+}
+
+// credentials returns the username and password for domain from the auth
+// file, or empty strings if there are none
+func (c *Client) credentials(domain string) (string, string, error) {
+	if c.AuthFile == "" {
+		return "", "", nil
+	}
+	data, err := ioutil.ReadFile(c.AuthFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	var config authConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", fmt.Errorf("parsing %s: %v", c.AuthFile, err)
+	}
+	candidates := []string{domain, "https://" + domain}
+	if domain == dockerHubDomain {
+		candidates = append(candidates, "https://index.docker.io/v1/")
+	}
+	for _, candidate := range candidates {
+		entry, ok := config.Auths[candidate]
+		if !ok {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("decoding auth for %s in %s: %v", domain, c.AuthFile, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("malformed auth for %s in %s", domain, c.AuthFile)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", nil
+}
+
+// parseChallenge parses a WWW-Authenticate header into its scheme and parameters
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	scheme := strings.ToLower(parts[0])
+	if len(parts) == 1 {
+		return scheme, params
+	}
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+// authorize answers the challenge from an unauthorized response, returning
+// the Authorization header value to retry with
+func (c *Client) authorize(domain string, resp *http.Response) (string, error) {
+	username, password, err := c.credentials(domain)
+	if err != nil {
+		return "", err
+	}
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	switch scheme {
+	case "basic":
+		if username == "" {
+			return "", fmt.Errorf("%s requires credentials", domain)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", fmt.Errorf("invalid token realm %q from %s", params["realm"], domain)
+		}
+		query := realm.Query()
+		if service, ok := params["service"]; ok {
+			query.Set("service", service)
+		}
+		if scope, ok := params["scope"]; ok {
+			query.Set("scope", scope)
+		}
+		realm.RawQuery = query.Encode()
+		req, err := http.NewRequest("GET", realm.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		client, err := c.HTTPClientFor(realm.Host)
+		if err != nil {
+			return "", err
+		}
+		tokenResp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer tokenResp.Body.Close()
+		if tokenResp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("requesting token from %s: %s", realm.Host, tokenResp.Status)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(tokenResp.Body).Decode(&token); err != nil {
+			return "", fmt.Errorf("parsing token from %s: %v", realm.Host, err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		return "Bearer " + token.Token, nil
+	}
+	return "", fmt.Errorf("unsupported authentication scheme %q from %s", scheme, domain)
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CertsDirs hold a directory of CAs and client certificates for each
+// registry, as described in containers-certs.d(5). Only the first directory
+// found for a registry is used.
+var CertsDirs = []string{"/etc/containers/certs.d", "/etc/docker/certs.d"}
+
+// tlsConfig returns the TLS configuration for connecting to domain, which
+// trusts the *.crt CAs and presents the *.cert and *.key client
+// certificates of its directory in c.CertsDirs
+func (c *Client) tlsConfig(domain string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	for _, certsDir := range c.CertsDirs {
+		dir := filepath.Join(certsDir, domain)
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			switch filepath.Ext(entry.Name()) {
+			case ".crt":
+				if config.RootCAs == nil {
+					if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+						return nil, err
+					}
+				}
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				if !config.RootCAs.AppendCertsFromPEM(data) {
+					return nil, fmt.Errorf("no certificates found in %s", path)
+				}
+			case ".cert":
+				keyPath := strings.TrimSuffix(path, ".cert") + ".key"
+				cert, err := tls.LoadX509KeyPair(path, keyPath)
+				if err != nil {
+					return nil, fmt.Errorf("loading client certificate %s: %v", path, err)
+				}
+				config.Certificates = append(config.Certificates, cert)
+			case ".key":
+				certPath := strings.TrimSuffix(path, ".key") + ".cert"
+				if _, err := os.Stat(certPath); err != nil {
+					return nil, fmt.Errorf("client key %s has no certificate: %v", path, err)
+				}
+			}
+		}
+		break
+	}
+	return config, nil
+}
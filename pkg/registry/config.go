@@ -0,0 +1,212 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	imgref "github.com/containers/image/docker/reference"
+)
+
+// RegistriesConfFile configures registry mirrors and blocked registries, as
+// described in registries.conf(5)
+const RegistriesConfFile = "/etc/containers/registries.conf"
+
+// registriesConf is the subset of a registries.conf file which is used, in
+// either the version 2 or the older version 1 format
+type registriesConf struct {
+	Registries []registryConf `toml:"registry"`
+	V1         struct {
+		Insecure struct {
+			Registries []string `toml:"registries"`
+		} `toml:"insecure"`
+		Block struct {
+			Registries []string `toml:"registries"`
+		} `toml:"block"`
+	} `toml:"registries"`
+}
+
+// registryConf is a [[registry]] table of registries.conf
+type registryConf struct {
+	Prefix             string       `toml:"prefix"`                // The image names the entry applies to, defaults to Location
+	Location           string       `toml:"location"`              // What Prefix is replaced with to pull
+	Insecure           bool         `toml:"insecure"`              // Do not verify the TLS certificate of Location
+	Blocked            bool         `toml:"blocked"`               // Refuse to pull images with Prefix
+	MirrorByDigestOnly bool         `toml:"mirror-by-digest-only"` // Only use Mirrors for references with a digest
+	Mirrors            []mirrorConf `toml:"mirror"`                // Tried in order before Location
+}
+
+// mirrorConf is a [[registry.mirror]] table of registries.conf
+type mirrorConf struct {
+	Location string `toml:"location"` // What the Prefix of the registry is replaced with to pull
+	Insecure bool   `toml:"insecure"` // Do not verify the TLS certificate of Location
+}
+
+// ignoredRegistriesKeys are the registries.conf keys which only apply to
+// short names, while pivot always uses fully qualified references
+var ignoredRegistriesKeys = []string{
+	"unqualified-search-registries",
+	"short-name-mode",
+	"aliases",
+	"registries.search",
+}
+
+// isIgnoredKey returns true if key is or is within one of ignoredRegistriesKeys
+func isIgnoredKey(key toml.Key) bool {
+	for _, ignored := range ignoredRegistriesKeys {
+		if s := key.String(); s == ignored || strings.HasPrefix(s, ignored+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRegistriesConf reads the registries configured in path. A missing
+// file configures none. Settings which are not implemented are an error
+// rather than being ignored, as they could change which images are pulled.
+func loadRegistriesConf(path string) ([]registryConf, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var conf registriesConf
+	meta, err := toml.DecodeFile(path, &conf)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for _, key := range meta.Undecoded() {
+		if !isIgnoredKey(key) {
+			return nil, fmt.Errorf("parsing %s: %q is not supported", path, key.String())
+		}
+	}
+
+	v1 := map[string]*registryConf{}
+	v1Entry := func(location string) *registryConf {
+		if v1[location] == nil {
+			v1[location] = &registryConf{Location: location}
+		}
+		return v1[location]
+	}
+	for _, location := range conf.V1.Insecure.Registries {
+		v1Entry(location).Insecure = true
+	}
+	for _, location := range conf.V1.Block.Registries {
+		v1Entry(location).Blocked = true
+	}
+	if len(v1) > 0 && len(conf.Registries) > 0 {
+		return nil, fmt.Errorf("parsing %s: mixes the version 1 and 2 formats", path)
+	}
+	for _, reg := range v1 {
+		conf.Registries = append(conf.Registries, *reg)
+	}
+
+	prefixes := map[string]bool{}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if reg.Prefix == "" {
+			reg.Prefix = reg.Location
+		}
+		if reg.Location == "" {
+			reg.Location = reg.Prefix
+		}
+		if reg.Prefix == "" {
+			return nil, fmt.Errorf("parsing %s: a registry has neither a prefix nor a location", path)
+		}
+		if strings.HasPrefix(reg.Prefix, "*.") {
+			return nil, fmt.Errorf("parsing %s: wildcard prefix %q is not supported", path, reg.Prefix)
+		}
+		if prefixes[reg.Prefix] {
+			return nil, fmt.Errorf("parsing %s: prefix %q is configured more than once", path, reg.Prefix)
+		}
+		prefixes[reg.Prefix] = true
+	}
+	return conf.Registries, nil
+}
+
+// matchesPrefix returns true if the image name is prefix or is within it
+func matchesPrefix(name, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// findRegistry returns the entry of registries with the longest prefix
+// matching the image name, or nil if there is none
+func findRegistry(registries []registryConf, name string) *registryConf {
+	var found *registryConf
+	for i, reg := range registries {
+		if matchesPrefix(name, reg.Prefix) && (found == nil || len(reg.Prefix) > len(found.Prefix)) {
+			found = &registries[i]
+		}
+	}
+	return found
+}
+
+// isInsecure returns true if registries allow an unverified TLS certificate
+// for domain
+func isInsecure(registries []registryConf, domain string) bool {
+	inDomain := func(location string) bool {
+		return location == domain || strings.HasPrefix(location, domain+"/")
+	}
+	for _, reg := range registries {
+		if reg.Insecure && inDomain(reg.Location) {
+			return true
+		}
+		for _, mirror := range reg.Mirrors {
+			if mirror.Insecure && inDomain(mirror.Location) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rewriteReference returns ref with prefix replaced by location
+func rewriteReference(ref imgref.Named, prefix, location string) (imgref.Named, error) {
+	name := strings.TrimSuffix(location, "/") + strings.TrimPrefix(ref.Name(), strings.TrimSuffix(prefix, "/"))
+	named, err := imgref.ParseNamed(name)
+	if err != nil {
+		return nil, fmt.Errorf("rewriting %s to %s: %v", ref, name, err)
+	}
+	if canon, ok := ref.(imgref.Canonical); ok {
+		return imgref.WithDigest(named, canon.Digest())
+	}
+	if tagged, ok := ref.(imgref.NamedTagged); ok {
+		return imgref.WithTag(named, tagged.Tag())
+	}
+	return named, nil
+}
+
+// sources returns the references ref is fetched from, in the order they are
+// tried: the mirrors of its registry followed by its location. An error is
+// returned if its registry is blocked.
+func (c *Client) sources(ref imgref.Named) ([]imgref.Named, error) {
+	registries, err := loadRegistriesConf(c.RegistriesFile)
+	if err != nil {
+		return nil, err
+	}
+	reg := findRegistry(registries, ref.Name())
+	if reg == nil {
+		return []imgref.Named{ref}, nil
+	}
+	if reg.Blocked {
+		return nil, fmt.Errorf("registry %s is blocked in %s", reg.Prefix, c.RegistriesFile)
+	}
+	locations := []string{}
+	if _, ok := ref.(imgref.Canonical); ok || !reg.MirrorByDigestOnly {
+		for _, mirror := range reg.Mirrors {
+			locations = append(locations, mirror.Location)
+		}
+	}
+	locations = append(locations, reg.Location)
+	sources := []imgref.Named{}
+	for _, location := range locations {
+		source, err := rewriteReference(ref, reg.Prefix, location)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
@@ -0,0 +1,280 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestLoadRegistriesConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registries")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "registries.conf")
+
+	if registries, err := loadRegistriesConf(path); err != nil || registries != nil {
+		t.Fatalf("Expected no registries without the file, got %v %v", registries, err)
+	}
+
+	ioutil.WriteFile(path, []byte(`
+unqualified-search-registries = ["registry.example.com"]
+
+[[registry]]
+prefix = "registry.example.com/os"
+location = "mirror.example.com/os"
+mirror-by-digest-only = true
+
+[[registry.mirror]]
+location = "local.example.com:5000/os"
+insecure = true
+
+[[registry]]
+location = "registry.example.com"
+`), 0644)
+	registries, err := loadRegistriesConf(path)
+	if err != nil || len(registries) != 2 {
+		t.Fatalf("Expected 2 registries, got %+v %v", registries, err)
+	}
+	if reg := findRegistry(registries, "registry.example.com/os"); reg == nil || reg.Location != "mirror.example.com/os" || len(reg.Mirrors) != 1 {
+		t.Fatalf("Expected the longest prefix to match, got %+v", reg)
+	}
+	if reg := findRegistry(registries, "registry.example.com/osx"); reg == nil || reg.Prefix != "registry.example.com" {
+		t.Fatalf("Expected only whole path components to match, got %+v", reg)
+	}
+	if !isInsecure(registries, "local.example.com:5000") || isInsecure(registries, "registry.example.com") {
+		t.Fatalf("Expected only the mirror to be insecure")
+	}
+
+	ioutil.WriteFile(path, []byte(`
+[registries.search]
+registries = ["registry.example.com"]
+[registries.insecure]
+registries = ["local.example.com:5000"]
+[registries.block]
+registries = ["docker.io", "local.example.com:5000"]
+`), 0644)
+	registries, err = loadRegistriesConf(path)
+	if err != nil || len(registries) != 2 {
+		t.Fatalf("Expected 2 registries from the version 1 format, got %+v %v", registries, err)
+	}
+	if reg := findRegistry(registries, "local.example.com:5000/os"); reg == nil || !reg.Blocked || !reg.Insecure {
+		t.Fatalf("Expected a blocked and insecure registry, got %+v", reg)
+	}
+
+	// What is not implemented is an error rather than being ignored
+	for _, content := range []string{
+		"[[registry]]\nlocation = \"registry.example.com\"\npull-from-mirror = \"digest-only\"\n",
+		"[[registry]]\nprefix = \"*.example.com\"\nblocked = true\n",
+		"[[registry]]\nlocation = \"registry.example.com\"\n[[registry]]\nprefix = \"registry.example.com\"\n",
+		"[[registry]]\nlocation = \"registry.example.com\"\n[registries.block]\nregistries = [\"docker.io\"]\n",
+		"[[registry]]\nblocked = true\n",
+		"[[registry]\n",
+	} {
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if _, err := loadRegistriesConf(path); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	server, client := newTestRegistry(t, true)
+	defer server.Close()
+	defer os.Remove(client.AuthFile)
+	client.Retries = 0
+
+	// The mirror has nothing, so the registry is used after it
+	var lock sync.Mutex
+	mirrored := []string{}
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		mirrored = append(mirrored, req.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mirror.Close()
+	client.Endpoints["mirror.example.com"] = mirror.URL
+
+	dir, err := ioutil.TempDir("", "registries")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	client.RegistriesFile = filepath.Join(dir, "registries.conf")
+	writeConf := func(content string) {
+		if err := ioutil.WriteFile(client.RegistriesFile, []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	writeConf(`
+[[registry]]
+location = "registry.example.com"
+[[registry.mirror]]
+location = "mirror.example.com/mirrored"
+`)
+	expected := digest.FromString(testManifest)
+	if dgst, err := client.ResolveDigest("registry.example.com/os:latest"); err != nil || dgst != expected {
+		t.Fatalf("Expected %s from the registry, got %s %v", expected, dgst, err)
+	}
+	if len(mirrored) != 1 || mirrored[0] != "/v2/mirrored/os/manifests/latest" {
+		t.Fatalf("Expected the mirror to be tried first, got %v", mirrored)
+	}
+
+	// Mirrors only for digests are not used for tags
+	writeConf(`
+[[registry]]
+location = "registry.example.com"
+mirror-by-digest-only = true
+[[registry.mirror]]
+location = "mirror.example.com/mirrored"
+`)
+	mirrored = []string{}
+	if _, err := client.ResolveDigest("registry.example.com/os:latest"); err != nil || len(mirrored) != 0 {
+		t.Fatalf("Expected only the registry to be used, got %v %v", mirrored, err)
+	}
+	if _, _, _, err := client.GetManifest("registry.example.com/os@" + expected.String()); err != nil || len(mirrored) != 1 {
+		t.Fatalf("Expected the mirror to be tried for a digest, got %v %v", mirrored, err)
+	}
+
+	// Blocked registries are refused before any request
+	writeConf(`
+[[registry]]
+prefix = "registry.example.com/os"
+blocked = true
+`)
+	mirrored = []string{}
+	if _, _, _, err := client.GetManifest("registry.example.com/os:latest"); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Fatalf("Expected the registry to be blocked, got %v", err)
+	}
+	if _, err := client.GetBlob("registry.example.com/os/sub:latest", expected); err == nil {
+		t.Fatalf("Expected repositories under a blocked prefix to be blocked")
+	}
+	if _, err := client.GetSignatures("registry.example.com/os@" + expected.String()); err == nil {
+		t.Fatalf("Expected signatures from a blocked registry to be refused")
+	}
+}
+
+// writeTestCert writes a new self-signed client certificate and its key to
+// certPath and keyPath, returning the certificate
+func writeTestCert(t *testing.T, certPath, keyPath string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pivot test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return cert
+}
+
+func TestCertsDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	clientCert := writeTestCert(t, filepath.Join(dir, "client.cert"), filepath.Join(dir, "client.key"))
+
+	// The registry requires a client certificate signed by clientCert
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", MediaTypeDockerManifest)
+		w.Write([]byte(testManifest))
+	}))
+	server.TLS = &tls.Config{ClientCAs: x509.NewCertPool(), ClientAuth: tls.RequireAndVerifyClientCert}
+	server.TLS.ClientCAs.AddCert(clientCert)
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	// Certificates are found for the registry domain in the first directory
+	// which has any
+	certsDir := filepath.Join(dir, "certs.d", "registry.example.com")
+	if err := os.MkdirAll(certsDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	client := NewClient("")
+	client.Retries = 0
+	client.RegistriesFile = ""
+	client.CertsDirs = []string{filepath.Join(dir, "certs.d"), filepath.Join(dir, "missing")}
+	client.Endpoints = map[string]string{"registry.example.com": server.URL}
+	if _, _, _, err := client.GetManifest("registry.example.com/os:latest"); err == nil {
+		t.Fatalf("Expected the registry not to be trusted without its CA")
+	}
+
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(certsDir, "ca.crt"), serverCA, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, name := range []string{"client.cert", "client.key"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(certsDir, name), data, 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	client = NewClient("")
+	client.Retries = 0
+	client.RegistriesFile = ""
+	client.CertsDirs = []string{filepath.Join(dir, "certs.d")}
+	client.Endpoints = map[string]string{"registry.example.com": server.URL}
+	if _, _, _, err := client.GetManifest("registry.example.com/os:latest"); err != nil {
+		t.Fatalf("Expected the CA and client certificate to be used, got %v", err)
+	}
+
+	// A key without its certificate is an error
+	if err := os.Remove(filepath.Join(certsDir, "client.cert")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := client.tlsConfig("registry.example.com", false); err == nil {
+		t.Fatalf("Expected a key without a certificate to be an error")
+	}
+	if config, err := client.tlsConfig("other.example.com", true); err != nil || !config.InsecureSkipVerify || config.RootCAs != nil {
+		t.Fatalf("Expected an insecure configuration without certificates, got %+v %v", config, err)
+	}
+}
@@ -0,0 +1,280 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	imgref "github.com/containers/image/docker/reference"
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+	"github.com/openshift/pivot/types"
+)
+
+// Descriptor references content in a registry
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is a Docker schema 2 or OCI image manifest
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// manifestList is a Docker manifest list or OCI image index
+type manifestList struct {
+	Manifests []struct {
+		Descriptor
+		Platform Platform `json:"platform"`
+	} `json:"manifests"`
+}
+
+// imageConfig is the subset of the image configuration which is used
+type imageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// Image is an image manifest and its metadata as found in a registry
+type Image struct {
+	Ref        imgref.Canonical      // The image reference in name@digest form, of the platform image for manifest lists
+	Manifest   Manifest              // The image manifest
+	Inspection types.ImageInspection // The image metadata
+}
+
+// GetBlob returns a reader for the blob dgst in the repository of ref. The
+// content is verified against dgst as it is read and an error is returned
+// at the end of the content if it does not match. If the connection fails
+// or stalls part way through, the rest of the blob is requested again.
+func (c *Client) GetBlob(ref string, dgst digest.Digest) (io.ReadCloser, error) {
+	named, err := imgref.ParseNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parsing reference: %q: %v", ref, err)
+	}
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+	sources, err := c.sources(named)
+	if err != nil {
+		return nil, err
+	}
+	blob := &blobReader{c: c, sources: sources, path: "blobs/" + dgst.String()}
+	if err := blob.open(); err != nil {
+		return nil, err
+	}
+	return &verifiedReader{
+		body:     blob,
+		verifier: dgst.Verifier(),
+		digest:   dgst,
+	}, nil
+}
+
+// timeoutReader cancels the request of body if a read stalls for
+// blobReadTimeout
+type timeoutReader struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Read implements io.Reader
+func (t *timeoutReader) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(blobReadTimeout, t.cancel)
+	defer timer.Stop()
+	return t.body.Read(p)
+}
+
+// Close implements io.Closer
+func (t *timeoutReader) Close() error {
+	t.cancel()
+	return t.body.Close()
+}
+
+// blobReader reads a blob, requesting the rest of it again if reading fails
+type blobReader struct {
+	c       *Client
+	sources []imgref.Named // Where the blob is requested from, only the one which responded once opened
+	path    string         // The path of the blob in the repository
+	body    io.ReadCloser  // The body of the current request
+	offset  int64          // How much of the blob was read
+	retries int            // How many times the blob was requested again
+}
+
+// open requests the blob from b.offset on
+func (b *blobReader) open() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	header := http.Header{}
+	if b.offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", b.offset))
+	}
+	resp, source, err := b.c.getFrom(ctx, "GET", b.sources, b.url, header)
+	if err != nil {
+		cancel()
+		return err
+	}
+	b.sources = []imgref.Named{source}
+	body := &timeoutReader{body: resp.Body, cancel: cancel}
+	if b.offset > 0 && resp.StatusCode == http.StatusOK {
+		// The registry ignored the range, so skip what was already read
+		if _, err := io.CopyN(ioutil.Discard, body, b.offset); err != nil {
+			body.Close()
+			return err
+		}
+	}
+	b.body = body
+	return nil
+}
+
+// url returns the URL of the blob in source
+func (b *blobReader) url(source imgref.Named) string {
+	return b.c.apiURL(source, b.path)
+}
+
+// Read implements io.Reader
+func (b *blobReader) Read(p []byte) (int, error) {
+	for {
+		n, err := b.body.Read(p)
+		b.offset += int64(n)
+		if err == nil || err == io.EOF || b.retries >= b.c.Retries {
+			return n, err
+		}
+		if n > 0 {
+			// The next read fails again
+			return n, nil
+		}
+		b.retries++
+		glog.Warningf("Reading %s failed after %d bytes: %v; retrying...", b.url(b.sources[0]), b.offset, err)
+		b.body.Close()
+		if err := b.open(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close implements io.Closer
+func (b *blobReader) Close() error {
+	return b.body.Close()
+}
+
+// verifiedReader checks the content read from body matches digest
+type verifiedReader struct {
+	body     io.ReadCloser
+	verifier digest.Verifier
+	digest   digest.Digest
+}
+
+// Read implements io.Reader
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.verifier.Write(p[:n])
+	if err == io.EOF && !v.verifier.Verified() {
+		return n, fmt.Errorf("content does not match digest %s", v.digest)
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (v *verifiedReader) Close() error {
+	return v.body.Close()
+}
+
+// selectPlatform returns the digest of the image for c.Platform in the
+// manifest list data, or a *PlatformError if there is none. With
+// c.IgnorePlatform the only image of a list is used whatever its platform.
+func (c *Client) selectPlatform(ref string, data []byte) (digest.Digest, error) {
+	var list manifestList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return "", fmt.Errorf("parsing manifest list for %s: %v", ref, err)
+	}
+	available := []Platform{}
+	for _, m := range list.Manifests {
+		if m.Platform.Matches(c.Platform) {
+			return m.Digest, nil
+		}
+		available = append(available, m.Platform)
+	}
+	err := &PlatformError{Ref: ref, Platform: c.Platform, Available: available}
+	if c.IgnorePlatform && len(list.Manifests) == 1 {
+		glog.Warningf("Ignoring platform mismatch: %v", err)
+		return list.Manifests[0].Digest, nil
+	}
+	return "", err
+}
+
+// GetImage fetches the manifest and configuration of the image ref points
+// to. If ref is a manifest list the image for c.Platform is used.
+func (c *Client) GetImage(ref string) (Image, error) {
+	var img Image
+	data, mediaType, dgst, err := c.GetManifest(ref)
+	if err != nil {
+		return img, err
+	}
+	named, _, err := parseReference(ref)
+	if err != nil {
+		return img, err
+	}
+	if mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex {
+		platformDigest, err := c.selectPlatform(ref, data)
+		if err != nil {
+			return img, err
+		}
+		platformRef, err := imgref.WithDigest(imgref.TrimNamed(named), platformDigest)
+		if err != nil {
+			return img, err
+		}
+		if data, mediaType, dgst, err = c.GetManifest(platformRef.String()); err != nil {
+			return img, err
+		}
+	}
+	switch mediaType {
+	case MediaTypeDockerManifest, MediaTypeOCIManifest:
+	default:
+		return img, fmt.Errorf("%s has unsupported manifest type %q", ref, mediaType)
+	}
+	if err := json.Unmarshal(data, &img.Manifest); err != nil {
+		return img, fmt.Errorf("parsing manifest for %s: %v", ref, err)
+	}
+
+	if img.Ref, err = imgref.WithDigest(imgref.TrimNamed(named), dgst); err != nil {
+		return img, err
+	}
+
+	blob, err := c.GetBlob(ref, img.Manifest.Config.Digest)
+	if err != nil {
+		return img, fmt.Errorf("fetching configuration for %s: %v", ref, err)
+	}
+	defer blob.Close()
+	configData, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return img, fmt.Errorf("fetching configuration for %s: %v", ref, err)
+	}
+	var config imageConfig
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return img, fmt.Errorf("parsing configuration for %s: %v", ref, err)
+	}
+
+	img.Inspection = types.ImageInspection{
+		Name:         img.Ref.Name(),
+		Digest:       dgst,
+		RepoDigests:  []string{img.Ref.String()},
+		Labels:       config.Config.Labels,
+		Architecture: config.Architecture,
+		Os:           config.OS,
+	}
+	for _, layer := range img.Manifest.Layers {
+		img.Inspection.Layers = append(img.Inspection.Layers, layer.Digest.String())
+	}
+	return img, nil
+}
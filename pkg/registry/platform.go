@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// archAliases maps architecture names used outside Go, such as by uname -m,
+// to GOARCH values
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armhf":   "arm",
+	"i386":    "386",
+	"i486":    "386",
+	"i586":    "386",
+	"i686":    "386",
+}
+
+// Platform is the operating system and architecture an image is built for,
+// using GOOS and GOARCH values as in the image configuration
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// HostPlatform returns the platform pivot is running on. The architecture
+// is that of the kernel rather than the one pivot was built for, which
+// differs when a 32-bit pivot runs on a 64-bit host.
+func HostPlatform() Platform {
+	return Platform{Architecture: hostArch(), OS: runtime.GOOS}
+}
+
+// unameArch returns the GOARCH value for a machine reported by uname -m
+func unameArch(machine string) string {
+	if strings.HasPrefix(machine, "armv") {
+		// armv6l, armv7l and the like
+		return "arm"
+	}
+	return normalizeArch(machine)
+}
+
+// String returns the platform as os/architecture[/variant]
+func (p Platform) String() string {
+	s := fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// normalizeArch returns the GOARCH value for arch
+func normalizeArch(arch string) string {
+	arch = strings.ToLower(arch)
+	if alias, ok := archAliases[arch]; ok {
+		return alias
+	}
+	return arch
+}
+
+// Matches returns true if an image for p runs on other. Variants are only
+// compared if both platforms have one.
+func (p Platform) Matches(other Platform) bool {
+	if !strings.EqualFold(p.OS, other.OS) || normalizeArch(p.Architecture) != normalizeArch(other.Architecture) {
+		return false
+	}
+	return p.Variant == "" || other.Variant == "" || p.Variant == other.Variant
+}
+
+// PlatformError is returned when a manifest list has no image for the platform
+type PlatformError struct {
+	Ref       string     // The manifest list reference
+	Platform  Platform   // The platform which was wanted
+	Available []Platform // The platforms in the manifest list
+}
+
+// Error implements the error interface
+func (e *PlatformError) Error() string {
+	available := []string{}
+	for _, p := range e.Available {
+		available = append(available, p.String())
+	}
+	return fmt.Sprintf("%s has no image for %s, only for %s", e.Ref, e.Platform, strings.Join(available, ", "))
+}
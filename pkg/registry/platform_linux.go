@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package registry
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// hostArch returns the architecture of the running kernel, as uname -m
+// reports it, or else the architecture pivot was built for
+func hostArch() string {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return runtime.GOARCH
+	}
+	machine := []byte{}
+	for _, c := range uts.Machine {
+		if c == 0 {
+			break
+		}
+		machine = append(machine, byte(c))
+	}
+	return unameArch(string(machine))
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package registry
+
+import "runtime"
+
+// hostArch returns the architecture pivot was built for, as only Linux
+// hosts are pivoted
+func hostArch() string {
+	return runtime.GOARCH
+}
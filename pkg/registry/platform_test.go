@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/openshift/pivot/pkg/registry/registrytest"
+)
+
+func TestPlatformMatches(t *testing.T) {
+	host := Platform{Architecture: "arm64", OS: "linux"}
+	for platform, expected := range map[Platform]bool{
+		{Architecture: "arm64", OS: "linux"}:                true,
+		{Architecture: "aarch64", OS: "linux"}:              true,
+		{Architecture: "arm64", OS: "linux", Variant: "v8"}: true,
+		{Architecture: "amd64", OS: "linux"}:                false,
+		{Architecture: "arm64", OS: "windows"}:              false,
+	} {
+		if platform.Matches(host) != expected {
+			t.Errorf("Expected %s matching %s to be %v", platform, host, expected)
+		}
+	}
+}
+
+func TestUnameArch(t *testing.T) {
+	for machine, expected := range map[string]string{
+		"x86_64":  "amd64",
+		"aarch64": "arm64",
+		"armv7l":  "arm",
+		"i686":    "386",
+		"ppc64le": "ppc64le",
+		"s390x":   "s390x",
+	} {
+		if arch := unameArch(machine); arch != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, machine, arch)
+		}
+	}
+	if arch := HostPlatform().Architecture; arch == "" || arch != normalizeArch(arch) {
+		t.Errorf("Expected the host architecture as a GOARCH value, got %q", arch)
+	}
+}
+
+func TestGetImageManifestList(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	client := NewClient("")
+	client.HTTPClient = server.Client()
+	client.RegistriesFile = ""
+	client.Endpoints = map[string]string{"registry.example.com": server.URL}
+
+	amd64 := registrytest.Config{Architecture: "amd64", OS: "linux"}
+	arm64 := registrytest.Config{Architecture: "arm64", OS: "linux"}
+	amd64Digest := server.AddImage("os", "", amd64)
+	arm64Digest := server.AddImage("os", "", arm64)
+	server.AddManifestList("os", "latest", map[registrytest.Platform]digest.Digest{
+		{Architecture: "amd64", OS: "linux"}: amd64Digest,
+		{Architecture: "arm64", OS: "linux"}: arm64Digest,
+	})
+
+	client.Platform = Platform{Architecture: "arm64", OS: "linux"}
+	img, err := client.GetImage("registry.example.com/os:latest")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if img.Ref.Digest() != arm64Digest || img.Inspection.Architecture != "arm64" {
+		t.Fatalf("Expected the arm64 image %s, got %s %s", arm64Digest, img.Ref, img.Inspection.Architecture)
+	}
+
+	client.Platform = Platform{Architecture: "s390x", OS: "linux"}
+	_, err = client.GetImage("registry.example.com/os:latest")
+	platformErr, ok := err.(*PlatformError)
+	if !ok {
+		t.Fatalf("Expected a PlatformError, got %v", err)
+	}
+	if len(platformErr.Available) != 2 {
+		t.Fatalf("Expected the 2 available platforms, got %v", platformErr)
+	}
+
+	// Ignoring the platform cannot choose between several images
+	client.IgnorePlatform = true
+	if _, err = client.GetImage("registry.example.com/os:latest"); err == nil {
+		t.Fatalf("Expected a PlatformError choosing between 2 images")
+	}
+
+	// But the only image of a list is used
+	server.AddManifestList("os", "arm64", map[registrytest.Platform]digest.Digest{
+		{Architecture: "arm64", OS: "linux"}: arm64Digest,
+	})
+	if img, err = client.GetImage("registry.example.com/os:arm64"); err != nil || img.Ref.Digest() != arm64Digest {
+		t.Fatalf("Expected the arm64 image ignoring the platform, got %v %v", img.Ref, err)
+	}
+	client.IgnorePlatform = false
+	if _, err = client.GetImage("registry.example.com/os:arm64"); err == nil {
+		t.Fatalf("Expected a PlatformError without ignoring the platform")
+	}
+}
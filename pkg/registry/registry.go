@@ -0,0 +1,353 @@
+// Package registry is a minimal client for the Docker Registry HTTP API V2
+// used to query images without pulling them.
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	imgref "github.com/containers/image/docker/reference"
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// dockerHubDomain is the domain used in references to Docker Hub images
+	dockerHubDomain = "docker.io"
+	// dockerHubEndpoint is the registry which serves dockerHubDomain
+	dockerHubEndpoint = "https://registry-1.docker.io"
+	// defaultRetries is how many times failed requests are retried, as
+	// many as pivot retries podman pull
+	defaultRetries = 5
+)
+
+// Timeouts for connecting to registries and waiting for their responses.
+// Reading a response is not limited as layers can be large, though a read
+// of a blob which stalls for blobReadTimeout is retried.
+const (
+	dialTimeout           = 30 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	responseHeaderTimeout = 60 * time.Second
+)
+
+// blobReadTimeout is how long a read of a blob may stall
+var blobReadTimeout = 60 * time.Second
+
+// Manifest media types which are accepted from registries
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// acceptedManifestTypes is sent in the Accept header of manifest requests
+var acceptedManifestTypes = []string{
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+}
+
+// StatusError is returned when a registry responds with an unexpected status
+type StatusError struct {
+	Method     string // The request method
+	URL        string // The requested URL
+	StatusCode int    // The HTTP status code of the response
+	Status     string // The HTTP status of the response
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// IsNotFound returns true if err is a StatusError for missing content
+func IsNotFound(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+// isTemporary returns true if err may not happen again, being a network
+// error or a server error status
+func isTemporary(err error) bool {
+	switch err := err.(type) {
+	case *StatusError:
+		return err.StatusCode >= 500 || err.StatusCode == http.StatusTooManyRequests
+	case *url.Error:
+		return err.Err != context.Canceled
+	}
+	return false
+}
+
+// Client queries image registries
+type Client struct {
+	AuthFile       string            // A docker/podman auth file with registry credentials, may be empty
+	HTTPClient     *http.Client      // The client used for all requests, defaults to one per domain with connection timeouts
+	Endpoints      map[string]string // Overrides the base URL used for a registry domain
+	Platform       Platform          // The platform selected from manifest lists
+	Retries        int               // How many times a request failing with a network or server error is retried
+	RetryDelay     time.Duration     // The sleep before the first retry, doubling after each
+	RegistriesFile string            // The registries.conf with mirrors and blocked registries, may be empty
+	CertsDirs      []string          // The certs.d directories with registry CAs and client certificates
+	// IgnorePlatform uses the only image of a manifest list with no image
+	// for Platform. Lists of several other platforms are still an error.
+	IgnorePlatform bool
+
+	clients map[string]*http.Client // The clients created for each domain
+}
+
+// NewClient returns a Client which reads credentials from authFile, honours
+// RegistriesConfFile and CertsDirs, and selects images for the host platform
+func NewClient(authFile string) *Client {
+	return &Client{
+		AuthFile:       authFile,
+		Platform:       HostPlatform(),
+		Retries:        defaultRetries,
+		RetryDelay:     5 * time.Second,
+		RegistriesFile: RegistriesConfFile,
+		CertsDirs:      CertsDirs,
+	}
+}
+
+// NewTransport returns a transport which limits the time taken to connect
+// and to wait for a response, but not to read it
+func NewTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+// HTTPClientFor returns the http.Client to use for requests to domain, with
+// the TLS configuration of its registry
+func (c *Client) HTTPClientFor(domain string) (*http.Client, error) {
+	if c.HTTPClient != nil {
+		return c.HTTPClient, nil
+	}
+	if client, ok := c.clients[domain]; ok {
+		return client, nil
+	}
+	registries, err := loadRegistriesConf(c.RegistriesFile)
+	if err != nil {
+		return nil, err
+	}
+	transport := NewTransport()
+	if transport.TLSClientConfig, err = c.tlsConfig(domain, isInsecure(registries, domain)); err != nil {
+		return nil, err
+	}
+	if c.clients == nil {
+		c.clients = map[string]*http.Client{}
+	}
+	c.clients[domain] = &http.Client{Transport: transport}
+	return c.clients[domain], nil
+}
+
+// retry calls fn until it succeeds, it fails with an error which is not
+// temporary or c.Retries retries were made, returning the last error
+func (c *Client) retry(what string, fn func() error) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(wait.Backoff{
+		Steps:    c.Retries + 1, // times to try
+		Duration: c.RetryDelay,  // sleep between tries
+		Factor:   2,             // factor by which to increase sleep
+	}, func() (bool, error) {
+		lastErr = fn()
+		if lastErr == nil {
+			return true, nil
+		}
+		if !isTemporary(lastErr) {
+			return false, lastErr
+		}
+		glog.Warningf("%s failed: %v; retrying...", what, lastErr)
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return lastErr
+	}
+	return err
+}
+
+// endpoint returns the base URL of the registry serving domain
+func (c *Client) endpoint(domain string) string {
+	if endpoint, ok := c.Endpoints[domain]; ok {
+		return strings.TrimSuffix(endpoint, "/")
+	}
+	if domain == dockerHubDomain {
+		return dockerHubEndpoint
+	}
+	return "https://" + domain
+}
+
+// apiURL returns the URL of path in the repository of ref
+func (c *Client) apiURL(ref imgref.Named, path string) string {
+	return fmt.Sprintf("%s/v2/%s/%s", c.endpoint(imgref.Domain(ref)), imgref.Path(ref), path)
+}
+
+// get performs a request against the registry serving ref, answering an
+// authentication challenge if needed. The caller must close the body of a
+// returned response, which always has a 200 status.
+func (c *Client) get(method string, ref imgref.Named, path string, accept []string) (*http.Response, error) {
+	header := http.Header{}
+	if len(accept) > 0 {
+		header.Set("Accept", strings.Join(accept, ", "))
+	}
+	sources, err := c.sources(ref)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := c.getFrom(context.Background(), method, sources, func(source imgref.Named) string {
+		return c.apiURL(source, path)
+	}, header)
+	return resp, err
+}
+
+// getFrom is like get(..) but tries each of sources in turn, requesting the
+// url returned for it and sending header with the request. A request with a
+// Range header may also have a 206 response. If every source fails with a
+// network or server error, they are all retried. The source which responded
+// is returned with the response, and otherwise the error of the last.
+func (c *Client) getFrom(ctx context.Context, method string, sources []imgref.Named, url func(imgref.Named) string, header http.Header) (*http.Response, imgref.Named, error) {
+	var resp *http.Response
+	var source imgref.Named
+	err := c.retry(method+" "+url(sources[len(sources)-1]), func() error {
+		var err error
+		for i := range sources {
+			source = sources[i]
+			resp, err = c.getURLOnce(ctx, method, imgref.Domain(source), url(source), header)
+			if err == nil {
+				return nil
+			}
+			if i < len(sources)-1 {
+				glog.Warningf("%s %s failed: %v; trying the next mirror...", method, url(source), err)
+			}
+		}
+		return err
+	})
+	return resp, source, err
+}
+
+// getURLOnce performs a single request for url on the registry serving
+// domain, as described by getFrom(..)
+func (c *Client) getURLOnce(ctx context.Context, method, domain, url string, header http.Header) (*http.Response, error) {
+	authorization := ""
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		client, err := c.HTTPClientFor(domain)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && authorization == "" {
+			authorization, err = c.authorize(domain, resp)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		partial := resp.StatusCode == http.StatusPartialContent && header.Get("Range") != ""
+		if resp.StatusCode != http.StatusOK && !partial {
+			resp.Body.Close()
+			return nil, &StatusError{Method: method, URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("%s %s: unauthorized", method, url)
+}
+
+// parseReference parses ref, which must include a tag or digest
+func parseReference(ref string) (imgref.Named, string, error) {
+	named, err := imgref.ParseNamed(ref)
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing reference: %q: %v", ref, err)
+	}
+	if canon, ok := named.(imgref.Canonical); ok {
+		return named, canon.Digest().String(), nil
+	}
+	if tagged, ok := named.(imgref.NamedTagged); ok {
+		return named, tagged.Tag(), nil
+	}
+	return nil, "", fmt.Errorf("reference %q has no tag or digest", ref)
+}
+
+// GetManifest fetches the manifest ref points to, returning its content,
+// media type and digest
+func (c *Client) GetManifest(ref string) ([]byte, string, digest.Digest, error) {
+	named, tagOrDigest, err := parseReference(ref)
+	if err != nil {
+		return nil, "", "", err
+	}
+	resp, err := c.get("GET", named, "manifests/"+tagOrDigest, acceptedManifestTypes)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+	dgst := digest.FromBytes(data)
+	if canon, ok := named.(imgref.Canonical); ok && canon.Digest() != dgst {
+		return nil, "", "", fmt.Errorf("manifest for %s has digest %s", ref, dgst)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	return data, strings.TrimSpace(mediaType), dgst, nil
+}
+
+// ResolveDigest returns the digest of the manifest ref points to without
+// downloading any image content
+func (c *Client) ResolveDigest(ref string) (digest.Digest, error) {
+	named, tagOrDigest, err := parseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	if canon, ok := named.(imgref.Canonical); ok {
+		return canon.Digest(), nil
+	}
+	resp, err := c.get("HEAD", named, "manifests/"+tagOrDigest, acceptedManifestTypes)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if header := resp.Header.Get("Docker-Content-Digest"); header != "" {
+		dgst, err := digest.Parse(header)
+		if err != nil {
+			return "", fmt.Errorf("invalid digest from registry for %s: %v", ref, err)
+		}
+		return dgst, nil
+	}
+	// Not every registry returns the digest header, so fall back to hashing
+	// the manifest
+	_, _, dgst, err := c.GetManifest(ref)
+	return dgst, err
+}
@@ -0,0 +1,210 @@
+package registry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const testManifest = `{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.v2+json"}`
+
+// newTestRegistry starts a registry stand-in serving testManifest as
+// os:latest which requires a bearer token obtained with user:pass
+func newTestRegistry(t *testing.T, sendDigest bool) (*httptest.Server, *Client) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/token":
+			if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if req.URL.Query().Get("scope") != "repository:os:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret"}`)
+		case req.Header.Get("Authorization") != "Bearer secret":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:os:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case req.URL.Path == "/v2/os/manifests/latest" || req.URL.Path == "/v2/os/manifests/"+digest.FromString(testManifest).String():
+			w.Header().Set("Content-Type", MediaTypeDockerManifest)
+			if sendDigest {
+				w.Header().Set("Docker-Content-Digest", digest.FromString(testManifest).String())
+			}
+			if req.Method == "GET" {
+				fmt.Fprint(w, testManifest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	authFile, err := ioutil.TempFile("", "auth")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fmt.Fprintf(authFile, `{"auths": {"registry.example.com": {"auth": "%s"}}}`, base64.StdEncoding.EncodeToString([]byte("user:pass")))
+	authFile.Close()
+
+	client := NewClient(authFile.Name())
+	client.HTTPClient = server.Client()
+	client.RegistriesFile = ""
+	client.Endpoints = map[string]string{"registry.example.com": server.URL}
+	return server, client
+}
+
+func TestResolveDigest(t *testing.T) {
+	expected := digest.FromString(testManifest)
+	for _, sendDigest := range []bool{true, false} {
+		server, client := newTestRegistry(t, sendDigest)
+		defer server.Close()
+		defer os.Remove(client.AuthFile)
+
+		dgst, err := client.ResolveDigest("registry.example.com/os:latest")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if dgst != expected {
+			t.Fatalf("Expected %s, got %s", expected, dgst)
+		}
+
+		if _, err := client.ResolveDigest("registry.example.com/os:missing"); err == nil {
+			t.Fatalf("Expected an error for a missing tag")
+		}
+	}
+
+	// Canonical references resolve without asking the registry
+	client := NewClient("")
+	client.Endpoints = map[string]string{"registry.example.com": "https://127.0.0.1:1"}
+	if dgst, err := client.ResolveDigest("registry.example.com/os@" + expected.String()); err != nil || dgst != expected {
+		t.Fatalf("Expected %s, got %s %v", expected, dgst, err)
+	}
+}
+
+func TestGetManifest(t *testing.T) {
+	server, client := newTestRegistry(t, true)
+	defer server.Close()
+	defer os.Remove(client.AuthFile)
+
+	data, mediaType, dgst, err := client.GetManifest("registry.example.com/os@" + digest.FromString(testManifest).String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(data) != testManifest || mediaType != MediaTypeDockerManifest || dgst != digest.FromString(testManifest) {
+		t.Fatalf("Unexpected manifest %s %s %s", data, mediaType, dgst)
+	}
+
+	// Without credentials the token request is refused
+	client.AuthFile = ""
+	if _, _, _, err := client.GetManifest("registry.example.com/os:latest"); err == nil || !strings.Contains(err.Error(), "token") {
+		t.Fatalf("Expected a token error, got %v", err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:os:pull,push"`)
+	if scheme != "bearer" {
+		t.Fatalf("Expected bearer, got %s", scheme)
+	}
+	if params["realm"] != "https://auth.example.com/token" || params["service"] != "registry.example.com" || params["scope"] != "repository:os:pull,push" {
+		t.Fatalf("Unexpected parameters %v", params)
+	}
+	if scheme, _ := parseChallenge(`Basic realm=registry`); scheme != "basic" {
+		t.Fatalf("Expected basic, got %s", scheme)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	blob := []byte(strings.Repeat("layer ", 100))
+	dgst := digest.FromBytes(blob)
+	var lock sync.Mutex
+	requests := map[string]int{}
+	ranges := []string{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		requests[req.URL.Path]++
+		count := requests[req.URL.Path]
+		if strings.Contains(req.URL.Path, "/blobs/") {
+			ranges = append(ranges, req.Header.Get("Range"))
+		}
+		lock.Unlock()
+		switch req.URL.Path {
+		case "/v2/os/manifests/flaky":
+			if count < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", MediaTypeDockerManifest)
+			fmt.Fprint(w, testManifest)
+		case "/v2/os/manifests/down":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/v2/os/blobs/" + dgst.String():
+			offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(req.Header.Get("Range"), "bytes="), "-"))
+			switch count {
+			case 1:
+				// The connection drops part way through
+				w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+				w.Write(blob[:100])
+			case 2:
+				// The response stalls part way through
+				w.Header().Set("Content-Length", strconv.Itoa(len(blob)-offset))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(blob[offset : offset+100])
+				w.(http.Flusher).Flush()
+				select {
+				case <-req.Context().Done():
+				case <-time.After(10 * time.Second):
+				}
+			default:
+				// The range is ignored
+				w.Write(blob)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(timeout time.Duration) { blobReadTimeout = timeout }(blobReadTimeout)
+	blobReadTimeout = 100 * time.Millisecond
+
+	client := NewClient("")
+	client.HTTPClient = server.Client()
+	client.RegistriesFile = ""
+	client.Endpoints = map[string]string{"registry.example.com": server.URL}
+	client.RetryDelay = 0
+
+	if _, _, _, err := client.GetManifest("registry.example.com/os:flaky"); err != nil || requests["/v2/os/manifests/flaky"] != 3 {
+		t.Fatalf("Expected success on the third request, got %d requests %v", requests["/v2/os/manifests/flaky"], err)
+	}
+	if _, _, _, err := client.GetManifest("registry.example.com/os:missing"); !IsNotFound(err) || requests["/v2/os/manifests/missing"] != 1 {
+		t.Fatalf("Expected a missing manifest not to be retried, got %d requests %v", requests["/v2/os/manifests/missing"], err)
+	}
+	client.Retries = 2
+	if _, _, _, err := client.GetManifest("registry.example.com/os:down"); err == nil || requests["/v2/os/manifests/down"] != 3 {
+		t.Fatalf("Expected an error after 2 retries, got %d requests %v", requests["/v2/os/manifests/down"], err)
+	}
+
+	reader, err := client.GetBlob("registry.example.com/os", dgst)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil || string(data) != string(blob) {
+		t.Fatalf("Expected the whole blob, got %d bytes %v", len(data), err)
+	}
+	if expected := []string{"", "bytes=100-", "bytes=200-"}; strings.Join(ranges, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected the blob to be resumed with %v, got %v", expected, ranges)
+	}
+}
@@ -0,0 +1,208 @@
+// Package registrytest provides a registry stand-in for tests.
+package registrytest
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Manifest media types served by Server
+const (
+	mediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeConfig   = "application/vnd.docker.container.image.v1+json"
+	mediaTypeLayer    = "application/vnd.docker.image.rootfs.diff.tar"
+	mediaTypeList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// Server is an in memory registry serving manifests and blobs over TLS
+type Server struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	manifests map[string]map[string][]byte // repository to tag or digest to manifest
+	types     map[string]string            // manifest digest to media type
+	blobs     map[digest.Digest][]byte
+}
+
+// NewServer starts a Server. Close must be called when done.
+func NewServer() *Server {
+	s := &Server{
+		manifests: map[string]map[string][]byte{},
+		types:     map[string]string{},
+		blobs:     map[digest.Digest][]byte{},
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Config is the subset of an image configuration Server generates
+type Config struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		Labels map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+}
+
+// descriptor references a blob or manifest
+type descriptor struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	Size      int64         `json:"size"`
+}
+
+// AddBlob stores data and returns its digest
+func (s *Server) AddBlob(data []byte) digest.Digest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dgst := digest.FromBytes(data)
+	s.blobs[dgst] = data
+	return dgst
+}
+
+// PutBlob stores data as dgst without verifying it, to serve corrupted content
+func (s *Server) PutBlob(dgst digest.Digest, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blobs[dgst] = data
+}
+
+// AddManifest stores a manifest of mediaType in repo under tag, which may
+// be empty, and returns its digest
+func (s *Server) AddManifest(repo, tag, mediaType string, data []byte) digest.Digest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dgst := digest.FromBytes(data)
+	if s.manifests[repo] == nil {
+		s.manifests[repo] = map[string][]byte{}
+	}
+	s.manifests[repo][dgst.String()] = data
+	if tag != "" {
+		s.manifests[repo][tag] = data
+	}
+	s.types[dgst.String()] = mediaType
+	return dgst
+}
+
+// AddImage stores an image built from config and uncompressed tar layers in
+// repo under tag and returns the manifest digest
+func (s *Server) AddImage(repo, tag string, config Config, layers ...[]byte) digest.Digest {
+	configData, _ := json.Marshal(config)
+	manifest := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifest,
+		Config:        descriptor{MediaType: mediaTypeConfig, Digest: s.AddBlob(configData), Size: int64(len(configData))},
+		Layers:        []descriptor{},
+	}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, descriptor{MediaType: mediaTypeLayer, Digest: s.AddBlob(layer), Size: int64(len(layer))})
+	}
+	data, _ := json.Marshal(manifest)
+	return s.AddManifest(repo, tag, mediaTypeManifest, data)
+}
+
+// Platform identifies an image in a manifest list
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// AddManifestList stores a manifest list of the images in repo for each
+// platform under tag and returns its digest
+func (s *Server) AddManifestList(repo, tag string, images map[Platform]digest.Digest) digest.Digest {
+	type entry struct {
+		descriptor
+		Platform Platform `json:"platform"`
+	}
+	list := struct {
+		SchemaVersion int     `json:"schemaVersion"`
+		MediaType     string  `json:"mediaType"`
+		Manifests     []entry `json:"manifests"`
+	}{SchemaVersion: 2, MediaType: mediaTypeList}
+	s.lock.Lock()
+	for platform, dgst := range images {
+		size := int64(len(s.manifests[repo][dgst.String()]))
+		list.Manifests = append(list.Manifests, entry{descriptor{MediaType: s.types[dgst.String()], Digest: dgst, Size: size}, platform})
+	}
+	s.lock.Unlock()
+	sort.Slice(list.Manifests, func(i, j int) bool { return list.Manifests[i].Digest < list.Manifests[j].Digest })
+	data, _ := json.Marshal(list)
+	return s.AddManifest(repo, tag, mediaTypeList, data)
+}
+
+// Domain returns the host:port of the server for use in image references
+func (s *Server) Domain() string {
+	return strings.TrimPrefix(s.URL, "https://")
+}
+
+// serveHTTP implements the subset of the registry API used by pivot
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		repo, ref := path[:i], path[i+len("/manifests/"):]
+		data, ok := s.manifests[repo][ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		dgst := digest.FromBytes(data)
+		w.Header().Set("Content-Type", s.types[dgst.String()])
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		if req.Method == "GET" {
+			w.Write(data)
+		}
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		data, ok := s.blobs[digest.Digest(path[i+len("/blobs/"):])]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// File is an entry in a layer built by Layer
+type File struct {
+	Name     string // The path within the layer
+	Content  string // The content of a regular file
+	Dir      bool   // If the entry is a directory
+	Linkname string // The target if the entry is a symlink
+}
+
+// Layer returns an uncompressed tar layer containing files
+func Layer(files ...File) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.Name, Mode: 0644, Size: int64(len(f.Content)), Typeflag: tar.TypeReg}
+		if f.Dir {
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		} else if f.Linkname != "" {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, f.Linkname, 0
+		}
+		tw.WriteHeader(hdr)
+		tw.Write([]byte(f.Content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	imgref "github.com/containers/image/docker/reference"
+)
+
+// GetSignatures returns the simple signing signatures of the image ref,
+// which must be in name@digest form, using the registry signature
+// extension API supported by the OpenShift registry
+func (c *Client) GetSignatures(ref string) ([][]byte, error) {
+	named, err := imgref.ParseNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parsing reference: %q: %v", ref, err)
+	}
+	canon, ok := named.(imgref.Canonical)
+	if !ok {
+		return nil, fmt.Errorf("not canonical form: %q", ref)
+	}
+	sources, err := c.sources(named)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := c.getFrom(context.Background(), "GET", sources, func(source imgref.Named) string {
+		return fmt.Sprintf("%s/extensions/v2/%s/signatures/%s", c.endpoint(imgref.Domain(source)), imgref.Path(source), canon.Digest())
+	}, nil)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Signatures []struct {
+			Content string `json:"content"`
+		} `json:"signatures"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("parsing signatures for %s: %v", ref, err)
+	}
+	signatures := [][]byte{}
+	for _, signature := range list.Signatures {
+		content, err := base64.StdEncoding.DecodeString(signature.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decoding signature for %s: %v", ref, err)
+		}
+		signatures = append(signatures, content)
+	}
+	return signatures, nil
+}
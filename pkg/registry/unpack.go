@@ -0,0 +1,205 @@
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// whiteoutPrefix marks a file deleted from the layers below
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque marks a directory whose lower layer contents are hidden
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// safeJoin returns name within root, refusing names which escape root
+// either directly or by passing through a symlink
+func safeJoin(root, name string) (string, error) {
+	cleaned := filepath.Clean("/" + name)
+	if cleaned == "/" {
+		return root, nil
+	}
+	current := root
+	components := strings.Split(strings.TrimPrefix(cleaned, "/"), "/")
+	for _, component := range components[:len(components)-1] {
+		current = filepath.Join(current, component)
+		info, err := os.Lstat(current)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("refusing to extract %q through symlink %q", name, current)
+		}
+	}
+	return filepath.Join(root, cleaned), nil
+}
+
+// ApplyLayer extracts the layer tarball, which may be gzip compressed, on top
+// of root. Whiteout files remove content extracted from earlier layers.
+func ApplyLayer(root string, layer io.Reader) error {
+	buffered := bufio.NewReader(layer)
+	var reader io.Reader = buffered
+	if magic, err := buffered.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	// Paths written by this layer must survive opaque whiteouts in it
+	created := map[string]bool{}
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target, err := safeJoin(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+		dir, base := filepath.Split(target)
+
+		if base == whiteoutOpaque {
+			entries, err := readDirNames(dir)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if path := filepath.Join(dir, entry); !created[path] {
+					if err := os.RemoveAll(path); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			// The name must be of a single entry in dir, so that a whiteout
+			// cannot remove dir, root or anything above root
+			name := base[len(whiteoutPrefix):]
+			if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+				return fmt.Errorf("invalid whiteout %q", hdr.Name)
+			}
+			deleted, err := safeJoin(root, filepath.Join(filepath.Dir(hdr.Name), name))
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(deleted); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		// Anything other than an existing directory kept for a directory
+		// entry is replaced
+		if info, err := os.Lstat(target); err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, mode); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := safeJoin(root, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		default:
+			glog.V(2).Infof("skipping %s with unsupported type %c", hdr.Name, hdr.Typeflag)
+			continue
+		}
+		created[target] = true
+		if hdr.Typeflag != tar.TypeSymlink {
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readDirNames returns the names in dir, or none if it does not exist
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// Unpack downloads the layers of img and extracts them in order into root
+func (c *Client) Unpack(img Image, root string) error {
+	for _, layer := range img.Manifest.Layers {
+		glog.Infof("Extracting layer %s", layer.Digest)
+		blob, err := c.GetBlob(img.Ref.String(), layer.Digest)
+		if err != nil {
+			return fmt.Errorf("fetching layer %s: %v", layer.Digest, err)
+		}
+		err = ApplyLayer(root, blob)
+		if err == nil {
+			// Drain the rest of the blob so the digest is verified
+			_, err = io.Copy(ioutil.Discard, blob)
+		}
+		blob.Close()
+		if err != nil {
+			return fmt.Errorf("extracting layer %s: %v", layer.Digest, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift/pivot/pkg/registry/registrytest"
+)
+
+func TestApplyLayer(t *testing.T) {
+	root, err := ioutil.TempDir("", "unpack")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+
+	lower := registrytest.Layer(
+		registrytest.File{Name: "dir", Dir: true},
+		registrytest.File{Name: "dir/lower", Content: "lower"},
+		registrytest.File{Name: "keep", Content: "keep"},
+		registrytest.File{Name: "link", Linkname: "/etc"})
+	if err := ApplyLayer(root, bytes.NewReader(lower)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The upper layer is compressed and hides the lower directory contents
+	upper := registrytest.Layer(
+		registrytest.File{Name: "dir/upper", Content: "upper"},
+		registrytest.File{Name: "dir/.wh..wh..opq"},
+		registrytest.File{Name: ".wh.keep"})
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(upper)
+	gz.Close()
+	if err := ApplyLayer(root, &compressed); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for path, exists := range map[string]bool{"dir/upper": true, "dir/lower": false, "keep": false} {
+		if _, err := os.Lstat(filepath.Join(root, path)); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v, got %v", path, exists, err)
+		}
+	}
+
+	// Nothing may be written outside of root
+	for _, name := range []string{"link/passwd", "../escape"} {
+		layer := registrytest.Layer(registrytest.File{Name: name, Content: "bad"})
+		err := ApplyLayer(root, bytes.NewReader(layer))
+		if name == "link/passwd" && err == nil {
+			t.Errorf("Expected an error extracting through a symlink")
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escape")); err == nil {
+			t.Errorf("Expected %s not to escape the root", name)
+		}
+	}
+}
+
+func TestApplyLayerInvalidWhiteout(t *testing.T) {
+	parent, err := ioutil.TempDir("", "unpack")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(parent)
+	root := filepath.Join(parent, "root")
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Whiteouts of . and .. would remove the root or its parent
+	for _, name := range []string{".wh...", ".wh..", "a/.wh...", "a/.wh.."} {
+		layer := registrytest.Layer(registrytest.File{Name: name})
+		if err := ApplyLayer(root, bytes.NewReader(layer)); err == nil {
+			t.Errorf("Expected an error for whiteout %s", name)
+		}
+		if _, err := os.Stat(filepath.Join(root, "a")); err != nil {
+			t.Fatalf("Expected whiteout %s not to remove anything, got %v", name, err)
+		}
+	}
+}
+
+func TestUnpackVerifiesDigest(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	client := NewClient("")
+	client.HTTPClient = server.Client()
+	client.RegistriesFile = ""
+
+	layer := registrytest.Layer(registrytest.File{Name: "file", Content: "content"})
+	ref := server.Domain() + "/os@" + server.AddImage("os", "", registrytest.Config{}, layer).String()
+	img, err := client.GetImage(ref)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	root, err := ioutil.TempDir("", "unpack")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	if err := client.Unpack(img, root); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A layer whose content does not match the manifest is rejected
+	server.PutBlob(img.Manifest.Layers[0].Digest, registrytest.Layer(registrytest.File{Name: "file", Content: "tampered"}))
+	if err := client.Unpack(img, root); err == nil {
+		t.Fatalf("Expected an error for a tampered layer")
+	}
+}
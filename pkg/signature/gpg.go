@@ -0,0 +1,236 @@
+package signature
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	imgref "github.com/containers/image/docker/reference"
+	"gopkg.in/yaml.v2"
+)
+
+// maxLookasideSignatures bounds how many signatures are read from a lookaside
+const maxLookasideSignatures = 32
+
+// lookasideTimeout bounds reading a signature from a lookaside, as
+// signatures are small
+var lookasideTimeout = 60 * time.Second
+
+// registriesD is a registries.d(5) configuration file
+type registriesD struct {
+	DefaultDocker *registryNamespace           `yaml:"default-docker"`
+	Docker        map[string]registryNamespace `yaml:"docker"`
+}
+
+// registryNamespace configures the signatures of a scope in registriesD
+type registryNamespace struct {
+	Lookaside              string `yaml:"lookaside"`                // Where signatures are read from
+	LookasideStaging       string `yaml:"lookaside-staging"`        // Where signatures are written, unused
+	Sigstore               string `yaml:"sigstore"`                 // The former name of Lookaside
+	SigstoreStaging        string `yaml:"sigstore-staging"`         // The former name of LookasideStaging, unused
+	UseSigstoreAttachments bool   `yaml:"use-sigstore-attachments"` // Unused as attachments are always read for sigstoreSigned
+}
+
+// location returns the lookaside of the namespace
+func (n registryNamespace) location() (string, error) {
+	if n.Lookaside != "" && n.Sigstore != "" {
+		return "", fmt.Errorf("both lookaside and sigstore are set")
+	}
+	if n.Lookaside != "" {
+		return n.Lookaside, nil
+	}
+	return n.Sigstore, nil
+}
+
+// parseRegistriesD reads the lookaside locations from the registries.d(5)
+// YAML files in dir. Unknown fields and scopes configured more than once
+// are an error. The "default-docker" location is returned under the empty
+// scope.
+func parseRegistriesD(dir string) (map[string]string, error) {
+	locations := map[string]string{}
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		config, err := readRegistriesD(file)
+		if err != nil {
+			return nil, err
+		}
+		namespaces := map[string]registryNamespace{}
+		for scope, namespace := range config.Docker {
+			if scope == "" {
+				return nil, fmt.Errorf("%s: docker has an empty scope", file)
+			}
+			namespaces[scope] = namespace
+		}
+		if config.DefaultDocker != nil {
+			namespaces[""] = *config.DefaultDocker
+		}
+		for scope, namespace := range namespaces {
+			location, err := namespace.location()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q: %v", file, scope, err)
+			}
+			if location == "" {
+				continue
+			}
+			if _, ok := locations[scope]; ok {
+				return nil, fmt.Errorf("%s: the lookaside of %q is configured more than once", file, scope)
+			}
+			locations[scope] = location
+		}
+	}
+	return locations, nil
+}
+
+// readRegistriesD strictly decodes the registries.d file, which must hold a
+// single YAML document
+func readRegistriesD(file string) (*registriesD, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var config registriesD
+	decoder := yaml.NewDecoder(f)
+	decoder.SetStrict(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing %s: %v", file, err)
+	}
+	var next interface{}
+	if err := decoder.Decode(&next); err != io.EOF {
+		return nil, fmt.Errorf("parsing %s: expected a single YAML document", file)
+	}
+	return &config, nil
+}
+
+// lookaside returns the lookaside base URL for ref, or an empty string
+func (v *Verifier) lookaside(ref imgref.Named) (string, error) {
+	if v.RegistriesDir == "" {
+		return "", nil
+	}
+	locations, err := parseRegistriesD(v.RegistriesDir)
+	if err != nil {
+		return "", err
+	}
+	for name := ref.Name(); ; {
+		if location, ok := locations[name]; ok {
+			return location, nil
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return locations[""], nil
+}
+
+// readLookaside reads the signature at location, returning nil if it does
+// not exist. Requests use the client of the registry and are abandoned
+// after lookasideTimeout.
+func (v *Verifier) readLookaside(location *url.URL) ([]byte, error) {
+	if location.Scheme == "file" {
+		data, err := ioutil.ReadFile(location.Path)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return data, err
+	}
+	client, err := v.Registry.HTTPClientFor(location.Host)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", location.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookasideTimeout)
+	defer cancel()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// gpgSignatures returns the simple signing signatures of ref from its
+// lookaside location if one is configured, otherwise from the registry
+func (v *Verifier) gpgSignatures(ref imgref.Canonical) ([][]byte, error) {
+	base, err := v.lookaside(ref)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		return v.Registry.GetSignatures(ref.String())
+	}
+	signatures := [][]byte{}
+	dgst := ref.Digest()
+	for i := 1; i <= maxLookasideSignatures; i++ {
+		location, err := url.Parse(fmt.Sprintf("%s/%s@%s=%s/signature-%d", strings.TrimSuffix(base, "/"), imgref.Path(ref), dgst.Algorithm(), dgst.Hex(), i))
+		if err != nil {
+			return nil, err
+		}
+		signature, err := v.readLookaside(location)
+		if err != nil {
+			return nil, err
+		}
+		if signature == nil {
+			break
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
+
+// verifyGPG checks the OpenPGP signed message was signed by one of the keys
+// using gpg with a temporary keyring, and returns the signed payload
+func (v *Verifier) verifyGPG(keys [][]byte, signature []byte) ([]byte, error) {
+	home, err := ioutil.TempDir("", "pivot-gpg-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(home)
+
+	for i, key := range keys {
+		keyFile := filepath.Join(home, fmt.Sprintf("key-%d", i))
+		if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+			return nil, err
+		}
+		if err := v.Runner.Run("gpg", "--homedir", home, "--batch", "--quiet", "--import", keyFile); err != nil {
+			return nil, fmt.Errorf("importing trusted keys: %v", err)
+		}
+	}
+	signatureFile := filepath.Join(home, "signature")
+	if err := ioutil.WriteFile(signatureFile, signature, 0600); err != nil {
+		return nil, err
+	}
+	statusFile := filepath.Join(home, "status")
+	payloadFile := filepath.Join(home, "payload")
+	if err := v.Runner.Run("gpg", "--homedir", home, "--batch", "--quiet", "--status-file", statusFile, "--output", payloadFile, "--decrypt", signatureFile); err != nil {
+		return nil, fmt.Errorf("not signed by a trusted key: %v", err)
+	}
+	status, err := ioutil.ReadFile(statusFile)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(string(status), "[GNUPG:] VALIDSIG ") {
+		return nil, fmt.Errorf("not signed by a trusted key")
+	}
+	return ioutil.ReadFile(payloadFile)
+}
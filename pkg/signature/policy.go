@@ -0,0 +1,169 @@
+// Package signature enforces containers-policy.json(5) signature policies
+// on images before pivot rebases to them.
+package signature
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	imgref "github.com/containers/image/docker/reference"
+)
+
+// Requirement types supported in a Policy
+const (
+	TypeInsecureAcceptAnything = "insecureAcceptAnything"
+	TypeReject                 = "reject"
+	TypeSignedBy               = "signedBy"
+	TypeSigstoreSigned         = "sigstoreSigned"
+)
+
+// Signed identity types supported in a Requirement
+const (
+	IdentityMatchRepoDigestOrExact = "matchRepoDigestOrExact"
+	IdentityMatchExact             = "matchExact"
+	IdentityMatchRepository        = "matchRepository"
+	IdentityExactReference         = "exactReference"
+	IdentityExactRepository        = "exactRepository"
+)
+
+// dockerTransport is the only transport pivot pulls images with
+const dockerTransport = "docker"
+
+// SignedIdentity restricts which identity a signature must claim
+type SignedIdentity struct {
+	Type             string `json:"type"`
+	DockerReference  string `json:"dockerReference,omitempty"`
+	DockerRepository string `json:"dockerRepository,omitempty"`
+}
+
+// Requirement is a single policy requirement an image must satisfy
+type Requirement struct {
+	Type           string          `json:"type"`
+	KeyType        string          `json:"keyType,omitempty"`        // signedBy only, must be GPGKeys
+	KeyPath        string          `json:"keyPath,omitempty"`        // A file with the trusted key(s)
+	KeyPaths       []string        `json:"keyPaths,omitempty"`       // Files with the trusted key(s)
+	KeyData        []byte          `json:"keyData,omitempty"`        // The trusted key(s) inline
+	SignedIdentity *SignedIdentity `json:"signedIdentity,omitempty"` // Defaults to matchRepoDigestOrExact
+}
+
+// String describes the requirement for error messages
+func (r Requirement) String() string {
+	keys := append([]string{}, r.KeyPaths...)
+	if r.KeyPath != "" {
+		keys = append(keys, r.KeyPath)
+	}
+	if len(r.KeyData) > 0 {
+		keys = append(keys, "inline key data")
+	}
+	if len(keys) == 0 {
+		return r.Type
+	}
+	return fmt.Sprintf("%s (%s)", r.Type, strings.Join(keys, ", "))
+}
+
+// Policy is a signature verification policy in containers-policy.json(5) format
+type Policy struct {
+	Default    []Requirement                       `json:"default"`
+	Transports map[string]map[string][]Requirement `json:"transports"`
+}
+
+// validate checks the requirements are ones pivot knows how to enforce
+func (r Requirement) validate() error {
+	switch r.Type {
+	case TypeInsecureAcceptAnything, TypeReject:
+		return nil
+	case TypeSignedBy:
+		if r.KeyType != "GPGKeys" {
+			return fmt.Errorf("unsupported keyType %q for %s", r.KeyType, r.Type)
+		}
+	case TypeSigstoreSigned:
+		if r.KeyType != "" {
+			return fmt.Errorf("keyType is not supported for %s", r.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported requirement type %q", r.Type)
+	}
+	if r.KeyPath == "" && len(r.KeyPaths) == 0 && len(r.KeyData) == 0 {
+		return fmt.Errorf("%s requires keyPath, keyPaths or keyData", r.Type)
+	}
+	if r.SignedIdentity != nil {
+		switch r.SignedIdentity.Type {
+		case IdentityMatchRepoDigestOrExact, IdentityMatchExact, IdentityMatchRepository, IdentityExactReference, IdentityExactRepository:
+		default:
+			return fmt.Errorf("unsupported signedIdentity type %q", r.SignedIdentity.Type)
+		}
+	}
+	return nil
+}
+
+// LoadPolicy reads and validates the policy at path. Fields pivot does not
+// implement, such as the fulcio and rekor settings of sigstoreSigned, are an
+// error rather than being ignored.
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var policy Policy
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if len(policy.Default) == 0 {
+		return nil, fmt.Errorf("%s: a default requirement is required", path)
+	}
+	scopes := map[string][]Requirement{"default": policy.Default}
+	for scope, requirements := range policy.Transports[dockerTransport] {
+		scopes[scope] = requirements
+	}
+	for scope, requirements := range scopes {
+		for _, requirement := range requirements {
+			if err := requirement.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", path, scope, err)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// scopes returns the policy scopes which may apply to ref, most specific first
+func scopes(ref imgref.Named) []string {
+	candidates := []string{ref.String()}
+	for name := ref.Name(); ; {
+		candidates = append(candidates, name)
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	domain := imgref.Domain(ref)
+	if i := strings.Index(domain, ":"); i >= 0 {
+		domain = domain[:i]
+	}
+	for {
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+		candidates = append(candidates, "*."+domain)
+	}
+	return candidates
+}
+
+// RequirementsFor returns the requirements which apply to ref and the scope
+// they were found in
+func (p *Policy) RequirementsFor(ref imgref.Named) ([]Requirement, string) {
+	docker := p.Transports[dockerTransport]
+	for _, scope := range scopes(ref) {
+		if requirements, ok := docker[scope]; ok {
+			return requirements, scope
+		}
+	}
+	return p.Default, "default"
+}
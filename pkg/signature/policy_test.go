@@ -0,0 +1,92 @@
+package signature
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	imgref "github.com/containers/image/docker/reference"
+)
+
+func TestScopes(t *testing.T) {
+	ref, err := imgref.ParseNamed("quay.io:443/openshift/release/os:latest")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := []string{
+		"quay.io:443/openshift/release/os:latest",
+		"quay.io:443/openshift/release/os",
+		"quay.io:443/openshift/release",
+		"quay.io:443/openshift",
+		"quay.io:443",
+		"*.io",
+	}
+	if got := scopes(ref); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy_test")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+
+	for _, tc := range []struct {
+		policy string
+		err    string
+	}{
+		{`{"transports": {}}`, "a default requirement is required"},
+		{`{"default": [{"type": "signedBy", "keyType": "X509Certificates", "keyPath": "/k"}]}`, `unsupported keyType "X509Certificates"`},
+		{`{"default": [{"type": "reject"}], "transports": {"docker": {"quay.io": [{"type": "sigstoreSigned"}]}}}`, "quay.io: sigstoreSigned requires keyPath"},
+		{`{"default": [{"type": "signedBaz"}]}`, `unsupported requirement type "signedBaz"`},
+		{`{"default": [{"type": "sigstoreSigned", "keyPath": "/k", "rekorPublicKeyPath": "/r"}]}`, `unknown field "rekorPublicKeyPath"`},
+		{`{"default": [{"type": "sigstoreSigned", "fulcio": {"caPath": "/ca"}, "keyPath": "/k"}]}`, `unknown field "fulcio"`},
+		{`{"default": [{"type": "sigstoreSigned", "keyType": "GPGKeys", "keyPath": "/k"}]}`, "keyType is not supported"},
+		{`{"default": [{"type": "reject"}], "transports": {"docker": {"": [{"type": "signedBy", "keyType": "GPGKeys", "keyPath": "/k", "signedIdentity": {"type": "remapIdentity", "prefix": "a", "signedPrefix": "b"}}]}}}`, `unknown field "prefix"`},
+		{`{"default": [{"type": "insecureAcceptAnything"}], "transports": {"docker": {"quay.io": [{"type": "sigstoreSigned", "keyPath": "/k"}]}}}`, ""},
+	} {
+		if err := ioutil.WriteFile(path, []byte(tc.policy), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		policy, err := LoadPolicy(path)
+		if tc.err == "" {
+			if err != nil || policy == nil {
+				t.Errorf("Expected %s to load, got %v", tc.policy, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Expected error containing %q for %s, got %v", tc.err, tc.policy, err)
+		}
+	}
+}
+
+func TestRequirementsFor(t *testing.T) {
+	policy := &Policy{
+		Default: []Requirement{{Type: TypeReject}},
+		Transports: map[string]map[string][]Requirement{
+			"docker": {
+				"quay.io/openshift": {{Type: TypeInsecureAcceptAnything}},
+				"*.example.com":     {{Type: TypeSigstoreSigned, KeyPath: "/k"}},
+			},
+		},
+	}
+	for ref, expected := range map[string]string{
+		"quay.io/openshift/os:latest":          "quay.io/openshift",
+		"quay.io/other/os:latest":              "default",
+		"registry.example.com/os:latest":       "*.example.com",
+		"mirror.registry.example.com/os:4.1.0": "*.example.com",
+	} {
+		named, err := imgref.ParseNamed(ref)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, scope := policy.RequirementsFor(named); scope != expected {
+			t.Errorf("Expected scope %s for %s, got %s", expected, ref, scope)
+		}
+	}
+}
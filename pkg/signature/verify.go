@@ -0,0 +1,279 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	imgref "github.com/containers/image/docker/reference"
+	"github.com/golang/glog"
+	"github.com/openshift/pivot/pkg/registry"
+	"github.com/openshift/pivot/utils"
+)
+
+const (
+	// gpgSignatureType is the critical type of a GPG simple signing signature
+	gpgSignatureType = "atomic container signature"
+	// sigstoreSignatureType is the critical type of a cosign signature payload
+	sigstoreSignatureType = "cosign container image signature"
+	// sigstoreSignatureAnnotation holds the base64 signature of a cosign payload layer
+	sigstoreSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// PolicyError reports the policy requirement an image did not satisfy
+type PolicyError struct {
+	Image       string      // The image which was verified
+	Scope       string      // The policy scope the requirement came from
+	Requirement Requirement // The requirement which was not met
+	Reason      string      // Why the requirement was not met
+}
+
+// Error implements the error interface
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s does not satisfy policy requirement %s for scope %q: %s", e.Image, e.Requirement, e.Scope, e.Reason)
+}
+
+// Verifier checks images against a Policy
+type Verifier struct {
+	Policy        *Policy          // The policy to enforce
+	Registry      *registry.Client // Fetches signatures from registries
+	Runner        *utils.Runner    // Runs gpg to verify signedBy signatures
+	RegistriesDir string           // registries.d(5) directory with lookaside locations, may be empty
+}
+
+// NewVerifier returns a Verifier enforcing policy
+func NewVerifier(policy *Policy, reg *registry.Client, r *utils.Runner, registriesDir string) *Verifier {
+	return &Verifier{
+		Policy:        policy,
+		Registry:      reg,
+		Runner:        r,
+		RegistriesDir: registriesDir,
+	}
+}
+
+// Verify returns nil if image, which must be in name@digest form, satisfies
+// every requirement of the policy, otherwise a *PolicyError
+func (v *Verifier) Verify(image string) error {
+	named, err := imgref.ParseNamed(image)
+	if err != nil {
+		return fmt.Errorf("parsing reference: %q: %v", image, err)
+	}
+	canon, ok := named.(imgref.Canonical)
+	if !ok {
+		return fmt.Errorf("signature verification requires a digest: %q", image)
+	}
+	requirements, scope := v.Policy.RequirementsFor(canon)
+	for _, requirement := range requirements {
+		if reason := v.check(canon, requirement); reason != "" {
+			return &PolicyError{Image: image, Scope: scope, Requirement: requirement, Reason: reason}
+		}
+	}
+	glog.Infof("%s satisfies the signature policy for scope %q", image, scope)
+	return nil
+}
+
+// check returns why ref does not satisfy requirement, or an empty string
+func (v *Verifier) check(ref imgref.Canonical, requirement Requirement) string {
+	switch requirement.Type {
+	case TypeInsecureAcceptAnything:
+		return ""
+	case TypeReject:
+		return "images are rejected"
+	}
+
+	keys, err := requirement.keys()
+	if err != nil {
+		return err.Error()
+	}
+	var signatures [][]byte
+	if requirement.Type == TypeSignedBy {
+		signatures, err = v.gpgSignatures(ref)
+	} else {
+		signatures, err = v.sigstoreSignatures(ref)
+	}
+	if err != nil {
+		return fmt.Sprintf("fetching signatures: %v", err)
+	}
+	if len(signatures) == 0 {
+		return "no signatures found"
+	}
+
+	reasons := []string{}
+	for i, signature := range signatures {
+		var payload []byte
+		if requirement.Type == TypeSignedBy {
+			payload, err = v.verifyGPG(keys, signature)
+		} else {
+			payload, err = verifySigstore(keys, signature)
+		}
+		if err == nil {
+			err = checkPayload(payload, ref, requirement)
+		}
+		if err == nil {
+			return ""
+		}
+		reasons = append(reasons, fmt.Sprintf("signature %d: %v", i+1, err))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// keys returns the trusted key material of the requirement
+func (r Requirement) keys() ([][]byte, error) {
+	keys := [][]byte{}
+	paths := append([]string{}, r.KeyPaths...)
+	if r.KeyPath != "" {
+		paths = append(paths, r.KeyPath)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, data)
+	}
+	if len(r.KeyData) > 0 {
+		keys = append(keys, r.KeyData)
+	}
+	return keys, nil
+}
+
+// payload is the signed content of both GPG and sigstore signatures
+type payload struct {
+	Critical struct {
+		Type  string `json:"type"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+	} `json:"critical"`
+}
+
+// checkPayload verifies a signed payload is for ref with an identity
+// accepted by the requirement
+func checkPayload(data []byte, ref imgref.Canonical, requirement Requirement) error {
+	var p payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("parsing signed payload: %v", err)
+	}
+	expectedType := gpgSignatureType
+	if requirement.Type == TypeSigstoreSigned {
+		expectedType = sigstoreSignatureType
+	}
+	if p.Critical.Type != expectedType {
+		return fmt.Errorf("unexpected signature type %q", p.Critical.Type)
+	}
+	if p.Critical.Image.DockerManifestDigest != ref.Digest().String() {
+		return fmt.Errorf("signature is for digest %s", p.Critical.Image.DockerManifestDigest)
+	}
+	identity, err := imgref.ParseNamed(p.Critical.Identity.DockerReference)
+	if err != nil {
+		return fmt.Errorf("invalid signed identity %q: %v", p.Critical.Identity.DockerReference, err)
+	}
+
+	signedIdentity := requirement.SignedIdentity
+	if signedIdentity == nil {
+		signedIdentity = &SignedIdentity{Type: IdentityMatchRepoDigestOrExact}
+	}
+	matched := false
+	switch signedIdentity.Type {
+	case IdentityMatchRepoDigestOrExact, IdentityMatchRepository:
+		// The image is always referenced by digest here
+		matched = identity.Name() == ref.Name()
+	case IdentityMatchExact:
+		matched = identity.String() == ref.String()
+	case IdentityExactReference:
+		matched = identity.String() == signedIdentity.DockerReference
+	case IdentityExactRepository:
+		matched = identity.Name() == signedIdentity.DockerRepository
+	}
+	if !matched {
+		return fmt.Errorf("signed identity %s does not match %s", identity, signedIdentity.Type)
+	}
+	return nil
+}
+
+// sigstoreSignatures returns the cosign signature layers attached to ref,
+// each as the base64 signature and payload joined by a newline
+func (v *Verifier) sigstoreSignatures(ref imgref.Canonical) ([][]byte, error) {
+	dgst := ref.Digest()
+	sigRef := fmt.Sprintf("%s:%s-%s.sig", ref.Name(), dgst.Algorithm(), dgst.Hex())
+	data, _, _, err := v.Registry.GetManifest(sigRef)
+	if err != nil {
+		if registry.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var manifest registry.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", sigRef, err)
+	}
+	signatures := [][]byte{}
+	for _, layer := range manifest.Layers {
+		signature, ok := layer.Annotations[sigstoreSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		blob, err := v.Registry.GetBlob(sigRef, layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(blob)
+		blob.Close()
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, append([]byte(signature+"\n"), content...))
+	}
+	return signatures, nil
+}
+
+// verifySigstore checks a signature from sigstoreSignatures against the
+// PEM public keys and returns the payload
+func verifySigstore(keys [][]byte, signature []byte) ([]byte, error) {
+	parts := strings.SplitN(string(signature), "\n", 2)
+	raw, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %v", err)
+	}
+	content := []byte(parts[1])
+	hashed := sha256.Sum256(content)
+	for _, key := range keys {
+		for rest := key; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing public key: %v", err)
+			}
+			valid := false
+			switch pub := pub.(type) {
+			case *ecdsa.PublicKey:
+				valid = ecdsa.VerifyASN1(pub, hashed[:], raw)
+			case *rsa.PublicKey:
+				valid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], raw) == nil ||
+					rsa.VerifyPSS(pub, crypto.SHA256, hashed[:], raw, nil) == nil
+			case ed25519.PublicKey:
+				valid = ed25519.Verify(pub, content, raw)
+			}
+			if valid {
+				return content, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("not signed by a trusted key")
+}
@@ -0,0 +1,309 @@
+package signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/openshift/pivot/pkg/registry"
+	"github.com/openshift/pivot/pkg/registry/registrytest"
+	"github.com/openshift/pivot/utils"
+)
+
+// newTestVerifier returns a Verifier enforcing requirement for every image
+// served by a registry stand-in for registry.example.com
+func newTestVerifier(requirement Requirement) (*registrytest.Server, *Verifier) {
+	server := registrytest.NewServer()
+	reg := registry.NewClient("")
+	reg.HTTPClient = server.Client()
+	reg.RegistriesFile = ""
+	reg.Endpoints = map[string]string{"registry.example.com": server.URL}
+	policy := &Policy{Default: []Requirement{requirement}}
+	return server, NewVerifier(policy, reg, utils.NewRunner(nil), "")
+}
+
+// testPayload returns a signed payload claiming identity for dgst
+func testPayload(signatureType, identity string, dgst digest.Digest) []byte {
+	return []byte(fmt.Sprintf(`{"critical": {"type": %q, "image": {"docker-manifest-digest": %q}, "identity": {"docker-reference": %q}}, "optional": {}}`,
+		signatureType, dgst, identity))
+}
+
+func TestVerifyAcceptAndReject(t *testing.T) {
+	server, v := newTestVerifier(Requirement{Type: TypeInsecureAcceptAnything})
+	defer server.Close()
+	image := "registry.example.com/os@" + server.AddImage("os", "latest", registrytest.Config{}).String()
+	if err := v.Verify(image); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	v.Policy.Transports = map[string]map[string][]Requirement{"docker": {"registry.example.com/os": {{Type: TypeReject}}}}
+	err := v.Verify(image)
+	policyErr, ok := err.(*PolicyError)
+	if !ok {
+		t.Fatalf("Expected a PolicyError, got %v", err)
+	}
+	if policyErr.Scope != "registry.example.com/os" || policyErr.Requirement.Type != TypeReject {
+		t.Fatalf("Expected the reject requirement for registry.example.com/os, got %+v", policyErr)
+	}
+
+	if err := v.Verify("registry.example.com/os:latest"); err == nil {
+		t.Fatalf("Expected an error verifying a tag")
+	}
+}
+
+func TestVerifySigstore(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	server, v := newTestVerifier(Requirement{
+		Type:    TypeSigstoreSigned,
+		KeyData: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}),
+	})
+	defer server.Close()
+	dgst := server.AddImage("os", "latest", registrytest.Config{})
+	image := "registry.example.com/os@" + dgst.String()
+
+	err = v.Verify(image)
+	if err == nil || !strings.Contains(err.Error(), "no signatures found") {
+		t.Fatalf("Expected no signatures to be found, got %v", err)
+	}
+
+	// sign adds a cosign signature of content to the image
+	sign := func(content []byte) {
+		hashed := sha256.Sum256(content)
+		raw, err := ecdsa.SignASN1(rand.Reader, key, hashed[:])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		manifest := registry.Manifest{
+			SchemaVersion: 2,
+			MediaType:     registry.MediaTypeOCIManifest,
+			Config:        registry.Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: server.AddBlob([]byte("{}")), Size: 2},
+			Layers: []registry.Descriptor{{
+				MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+				Digest:      server.AddBlob(content),
+				Size:        int64(len(content)),
+				Annotations: map[string]string{sigstoreSignatureAnnotation: base64.StdEncoding.EncodeToString(raw)},
+			}},
+		}
+		data, _ := json.Marshal(manifest)
+		server.AddManifest("os", "sha256-"+dgst.Hex()+".sig", registry.MediaTypeOCIManifest, data)
+	}
+
+	sign(testPayload(sigstoreSignatureType, "registry.example.com/other", dgst))
+	err = v.Verify(image)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("Expected the signed identity to be rejected, got %v", err)
+	}
+
+	sign(testPayload(sigstoreSignatureType, "registry.example.com/os:latest", dgst))
+	if err := v.Verify(image); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestVerifyGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	dir, err := ioutil.TempDir("", "verify_test")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Generate a signing key and export its public half
+	home := filepath.Join(dir, "gnupg")
+	if err := os.Mkdir(home, 0700); err != nil {
+		t.Fatalf("%v", err)
+	}
+	gpg := func(args ...string) {
+		args = append([]string{"--homedir", home, "--batch", "--quiet", "--pinentry-mode", "loopback", "--passphrase", ""}, args...)
+		if out, err := exec.Command("gpg", args...).CombinedOutput(); err != nil {
+			t.Fatalf("gpg %v: %v: %s", args, err, out)
+		}
+	}
+	gpg("--quick-generate-key", "pivot-test@example.com", "default", "default", "never")
+	keyPath := filepath.Join(dir, "key.gpg")
+	gpg("--output", keyPath, "--export", "pivot-test@example.com")
+
+	server, v := newTestVerifier(Requirement{Type: TypeSignedBy, KeyType: "GPGKeys", KeyPath: keyPath})
+	defer server.Close()
+	dgst := server.AddImage("openshift/os", "latest", registrytest.Config{})
+	image := "registry.example.com/openshift/os@" + dgst.String()
+
+	// Signatures are read from a file:// lookaside configured in registries.d
+	v.RegistriesDir = filepath.Join(dir, "registries.d")
+	lookaside := filepath.Join(dir, "sigstore")
+	if err := os.Mkdir(v.RegistriesDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	config := fmt.Sprintf("# Test lookaside\ndefault-docker:\n  lookaside: https://sigs.example.com\ndocker:\n  registry.example.com/openshift:\n    lookaside: file://%s\n", lookaside)
+	if err := ioutil.WriteFile(filepath.Join(v.RegistriesDir, "test.yaml"), []byte(config), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	err = v.Verify(image)
+	if err == nil || !strings.Contains(err.Error(), "no signatures found") {
+		t.Fatalf("Expected no signatures to be found, got %v", err)
+	}
+
+	sigDir := filepath.Join(lookaside, "openshift/os@sha256="+dgst.Hex())
+	if err := os.MkdirAll(sigDir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	payloadPath := filepath.Join(dir, "payload")
+	if err := ioutil.WriteFile(payloadPath, testPayload(gpgSignatureType, "registry.example.com/openshift/os:latest", dgst), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	gpg("--output", filepath.Join(sigDir, "signature-1"), "--sign", payloadPath)
+	if err := v.Verify(image); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A signature by another key is not trusted
+	if err := ioutil.WriteFile(keyPath, []byte{}, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := v.Verify(image); err == nil {
+		t.Fatalf("Expected a signature by an untrusted key to be rejected")
+	}
+}
+
+func TestParseRegistriesD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registries_test")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	config := `docker:
+  quay.io/openshift:
+    sigstore: https://sigs.example.com/openshift
+  "registry.example.com":
+    lookaside: file:///var/lib/sigs # local
+    lookaside-staging: file:///tmp
+  registry.example.com:5000/os:
+    lookaside: https://sigs.example.com/os#latest
+default-docker:
+  sigstore-staging: file:///tmp
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte(config), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	locations, err := parseRegistriesD(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(locations) != 3 || locations["quay.io/openshift"] != "https://sigs.example.com/openshift" || locations["registry.example.com"] != "file:///var/lib/sigs" ||
+		locations["registry.example.com:5000/os"] != "https://sigs.example.com/os#latest" {
+		t.Fatalf("Unexpected lookaside locations %v", locations)
+	}
+
+	// Any YAML style may be used
+	for _, config := range []string{
+		"docker: {quay.io: {lookaside: https://sigs.example.com}}\n",
+		"docker:\n  quay.io: {\"lookaside\": 'https://sigs.example.com'}\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte(config), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if locations, err := parseRegistriesD(dir); err != nil || len(locations) != 1 || locations["quay.io"] != "https://sigs.example.com" {
+			t.Errorf("Expected the quay.io lookaside from %q, got %v %v", config, locations, err)
+		}
+	}
+
+	// Configuration which could be misread is an error
+	for _, config := range []string{
+		"default-docker:\n  lookaside: https://a.example.com\n---\ndefault-docker:\n  lookaside: https://b.example.com\n",
+		"docker:\n  - quay.io\n",
+		"default-docker:\n\tlookaside: https://sigs.example.com\n",
+		"default-docker:\n  lookaside https://sigs.example.com\n",
+		"default-docker:\n  lookasied: https://sigs.example.com\n",
+		"default-docker:\n  lookaside: https://a.example.com\n  lookaside: https://b.example.com\n",
+		"default-docker:\n  lookaside: https://a.example.com\n  sigstore: https://b.example.com\n",
+		"docker:\n  quay.io:\n    lookaside: https://a.example.com\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte(config), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		// The last is an error as b.yaml configures quay.io too
+		if err := ioutil.WriteFile(filepath.Join(dir, "b.yaml"), []byte("docker:\n  quay.io:\n    lookaside: https://b.example.com\n"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := parseRegistriesD(dir); err == nil {
+			t.Errorf("Expected an error for %q", config)
+		}
+	}
+}
+
+func TestReadLookaside(t *testing.T) {
+	defer func(timeout time.Duration) { lookasideTimeout = timeout }(lookasideTimeout)
+	lookasideTimeout = 100 * time.Millisecond
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/signature-1":
+			w.Write([]byte("signature"))
+		case "/stalled":
+			w.Write([]byte("sig"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-req.Context().Done():
+			case <-done:
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+	reg := registry.NewClient("")
+	reg.RegistriesFile = ""
+	reg.CertsDirs = nil
+	v := NewVerifier(&Policy{}, reg, nil, "")
+
+	read := func(path string) ([]byte, error) {
+		location, err := url.Parse(server.URL + path)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return v.readLookaside(location)
+	}
+	if signature, err := read("/signature-1"); err != nil || string(signature) != "signature" {
+		t.Fatalf("Expected the signature, got %q %v", signature, err)
+	}
+	if signature, err := read("/signature-2"); err != nil || signature != nil {
+		t.Fatalf("Expected no signature, got %q %v", signature, err)
+	}
+
+	// A lookaside which stalls is abandoned
+	start := time.Now()
+	if _, err := read("/stalled"); err == nil {
+		t.Fatalf("Expected a stalled read to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Expected a stalled read to time out, took %s", elapsed)
+	}
+}
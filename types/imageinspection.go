@@ -40,9 +40,11 @@ type RpmOstreeDeployment struct {
 	OSName       string   `json:"osname"`
 	Serial       int32    `json:"serial"`
 	Checksum     string   `json:"checksum"`
+	BaseChecksum string   `json:"base-checksum"`
 	Version      string   `json:"version"`
 	Timestamp    uint64   `json:"timestamp"`
 	Booted       bool     `json:"booted"`
+	Staged       bool     `json:"staged"`
 	Origin       string   `json:"origin"`
 	CustomOrigin []string `json:"custom-origin"`
 }
@@ -1,8 +1,61 @@
 package types
 
+import "strings"
+
 // TuneArgument represents a single tuning argument
 type TuneArgument struct {
 	Key   string `json:"key"`   // The name of the argument (or argument itself if Bare)
 	Value string `json:"value"` // The value of the argument
 	Bare  bool   `json:"bare"`  // If the kernel argument is a bare argument (no value expected)
 }
+
+// ParseTuneArgument splits a kernel argument into its key and value. As
+// the kernel does, quotes around the argument or its value are removed.
+func ParseTuneArgument(arg string) TuneArgument {
+	if strings.HasPrefix(arg, `"`) {
+		arg = strings.TrimSuffix(arg[1:], `"`)
+	}
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) == 1 {
+		return TuneArgument{Key: arg, Bare: true}
+	}
+	value := parts[1]
+	if strings.HasPrefix(value, `"`) {
+		value = strings.TrimSuffix(value[1:], `"`)
+	}
+	return TuneArgument{Key: parts[0], Value: value}
+}
+
+// String returns the argument as it appears on the kernel command line.
+// Values containing whitespace are quoted.
+func (t TuneArgument) String() string {
+	if t.Bare {
+		return t.Key
+	}
+	if strings.ContainsAny(t.Value, " \t\n") {
+		return t.Key + `="` + t.Value + `"`
+	}
+	return t.Key + "=" + t.Value
+}
+
+// TuneReplacement represents changing the value of a kernel argument
+type TuneReplacement struct {
+	Key      string `json:"key"`      // The name of the argument
+	OldValue string `json:"oldValue"` // The value being replaced
+	Value    string `json:"value"`    // The new value
+}
+
+// Old returns the argument being replaced
+func (t TuneReplacement) Old() TuneArgument {
+	return TuneArgument{Key: t.Key, Value: t.OldValue}
+}
+
+// New returns the argument replacing Old
+func (t TuneReplacement) New() TuneArgument {
+	return TuneArgument{Key: t.Key, Value: t.Value}
+}
+
+// String returns the replacement in the key=old=new form rpm-ostree takes
+func (t TuneReplacement) String() string {
+	return t.Key + "=" + t.OldValue + "=" + t.Value
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FakeResponse is a scripted result for a command run through FakeExecutor
+type FakeResponse struct {
+	Output   string // What the command writes to stdout
+	Stderr   string // What the command writes to stderr
+	ExitCode int    // Non-zero exit codes cause a CommandError to be returned
+}
+
+// FakeExecutor is an Executor which records commands and returns scripted
+// responses instead of running anything.
+type FakeExecutor struct {
+	// Responses maps a command line prefix (command and leading arguments
+	// joined by spaces) to the responses returned in order. The longest
+	// matching prefix is used and its last response repeats once the others
+	// are consumed. Commands with no matching prefix succeed with no output.
+	Responses map[string][]FakeResponse
+	// Calls holds every command line executed, in order
+	Calls []string
+	// Env holds the environment variables added for each of Calls, nil
+	// for those run without any
+	Env [][]string
+}
+
+// NewFakeExecutor returns an empty FakeExecutor
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{Responses: map[string][]FakeResponse{}}
+}
+
+// On appends responses for command lines beginning with prefix
+func (f *FakeExecutor) On(prefix string, responses ...FakeResponse) *FakeExecutor {
+	f.Responses[prefix] = append(f.Responses[prefix], responses...)
+	return f
+}
+
+// Called returns true if a command line beginning with prefix was executed
+func (f *FakeExecutor) Called(prefix string) bool {
+	for _, call := range f.Calls {
+		if hasCommandPrefix(call, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute records the command line and returns the scripted response
+func (f *FakeExecutor) Execute(capture bool, command string, args ...string) ([]byte, error) {
+	return f.ExecuteEnv(capture, nil, command, args...)
+}
+
+// ExecuteEnv records the command line and env and returns the scripted response
+func (f *FakeExecutor) ExecuteEnv(capture bool, env []string, command string, args ...string) ([]byte, error) {
+	line := strings.TrimSpace(command + " " + strings.Join(args, " "))
+	f.Calls = append(f.Calls, line)
+	f.Env = append(f.Env, env)
+
+	match := ""
+	found := false
+	for prefix := range f.Responses {
+		if hasCommandPrefix(line, prefix) && (!found || len(prefix) > len(match)) {
+			match = prefix
+			found = true
+		}
+	}
+	if !found || len(f.Responses[match]) == 0 {
+		return []byte{}, nil
+	}
+	resp := f.Responses[match][0]
+	if len(f.Responses[match]) > 1 {
+		f.Responses[match] = f.Responses[match][1:]
+	}
+	if resp.ExitCode != 0 {
+		return nil, &CommandError{
+			Command:  command,
+			Args:     args,
+			ExitCode: resp.ExitCode,
+			Stderr:   resp.Stderr,
+			Err:      fmt.Errorf("exit status %d", resp.ExitCode),
+		}
+	}
+	if !capture {
+		return []byte{}, nil
+	}
+	return []byte(resp.Output), nil
+}
+
+// hasCommandPrefix checks if line is prefix or starts with prefix followed
+// by further arguments
+func hasCommandPrefix(line, prefix string) bool {
+	return line == prefix || strings.HasPrefix(line, prefix+" ")
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -11,20 +13,75 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
-// runImpl is the actual shell execution implementation used by other functions.
-func runImpl(capture bool, command string, args ...string) ([]byte, error) {
+// Executor abstracts how external commands are executed so callers can
+// swap in a fake implementation for testing.
+type Executor interface {
+	// Execute runs command with args. When capture is true stdout is
+	// returned instead of being passed through to the console.
+	Execute(capture bool, command string, args ...string) ([]byte, error)
+}
+
+// EnvExecutor is an Executor which can also add environment variables to
+// those a command inherits
+type EnvExecutor interface {
+	Executor
+	// ExecuteEnv is like Execute, but also sets env, a list of NAME=VALUE
+	ExecuteEnv(capture bool, env []string, command string, args ...string) ([]byte, error)
+}
+
+// CommandError is returned when an executed command fails
+type CommandError struct {
+	Command  string   // The command which was executed
+	Args     []string // The arguments passed to the command
+	ExitCode int      // The exit code of the command, -1 if it did not run
+	Stderr   string   // The captured standard error of the command
+	Err      error    // The underlying error
+}
+
+// Error implements the error interface
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s %s: %v", e.Command, strings.Join(e.Args, " "), e.Err)
+	if e.Stderr != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Stderr)
+	}
+	return msg
+}
+
+// ExecExecutor is the Executor which runs commands on the host
+type ExecExecutor struct{}
+
+// Execute runs the command on the host. Standard error is both passed through
+// to the console and captured for use in a returned CommandError.
+func (e ExecExecutor) Execute(capture bool, command string, args ...string) ([]byte, error) {
+	return e.ExecuteEnv(capture, nil, command, args...)
+}
+
+// ExecuteEnv runs the command on the host with env added to the environment
+func (ExecExecutor) ExecuteEnv(capture bool, env []string, command string, args ...string) ([]byte, error) {
 	glog.Infof("Running: %s %s\n", command, strings.Join(args, " "))
 	cmd := exec.Command(command, args...)
-	cmd.Stderr = os.Stderr
-	var stdout bytes.Buffer
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 	if !capture {
 		cmd.Stdout = os.Stdout
 	} else {
 		cmd.Stdout = &stdout
 	}
-	err := cmd.Run()
-	if err != nil {
-		return nil, err
+	if err := cmd.Run(); err != nil {
+		exitCode := -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+		return nil, &CommandError{
+			Command:  command,
+			Args:     args,
+			ExitCode: exitCode,
+			Stderr:   strings.TrimSpace(stderr.String()),
+			Err:      err,
+		}
 	}
 	if capture {
 		return stdout.Bytes(), nil
@@ -32,12 +89,33 @@ func runImpl(capture bool, command string, args ...string) ([]byte, error) {
 	return []byte{}, nil
 }
 
+// Runner provides the higher level command helpers on top of an Executor
+type Runner struct {
+	Executor   Executor      // Executes the commands
+	RetryDelay time.Duration // The initial sleep between retries in RunExt
+}
+
+// NewRunner returns a Runner using the provided Executor. If exe is nil the
+// commands are executed on the host.
+func NewRunner(exe Executor) *Runner {
+	if exe == nil {
+		exe = ExecExecutor{}
+	}
+	return &Runner{
+		Executor:   exe,
+		RetryDelay: 5 * time.Second,
+	}
+}
+
 // runExtBackoff is an extension to runExt that supports configuring retries/duration/backoff.
-func runExtBackoff(capture bool, backoff wait.Backoff, command string, args ...string) string {
+// The error of the last failed attempt is returned if all attempts fail.
+func (r *Runner) runExtBackoff(capture bool, backoff wait.Backoff, command string, args ...string) (string, error) {
 	var output string
+	var lastErr error
 	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
-		if out, e := runImpl(capture, command, args...); e != nil {
+		if out, e := r.Executor.Execute(capture, command, args...); e != nil {
 			glog.Warningf("%s failed: %v; retrying...", command, e)
+			lastErr = e
 			return false, nil
 		} else if capture {
 			output = strings.TrimSpace(string(out))
@@ -45,43 +123,57 @@ func runExtBackoff(capture bool, backoff wait.Backoff, command string, args ...s
 		return true, nil
 	})
 	if err != nil {
-		glog.Fatalf("%s: %s", command, err)
+		if lastErr != nil {
+			return "", lastErr
+		}
+		return "", err
 	}
-	return output
+	return output, nil
 }
 
 // RunExt executes a command, optionally capturing the output and retrying multiple
-// times before exiting with a fatal error.
-func RunExt(capture bool, retries int, command string, args ...string) string {
-	return runExtBackoff(capture, wait.Backoff{
-		Steps:    retries + 1,     // times to try
-		Duration: 5 * time.Second, // sleep between tries
-		Factor:   2,               // factor by which to increase sleep
+// times before returning an error.
+func (r *Runner) RunExt(capture bool, retries int, command string, args ...string) (string, error) {
+	return r.runExtBackoff(capture, wait.Backoff{
+		Steps:    retries + 1,  // times to try
+		Duration: r.RetryDelay, // sleep between tries
+		Factor:   2,            // factor by which to increase sleep
 	},
 		command, args...)
 }
 
-// Run executes a command, logging it, and exit with a fatal error if
-// the command failed.
-func Run(command string, args ...string) {
-	if _, err := runImpl(false, command, args...); err != nil {
-		glog.Fatalf("%s: %s", command, err)
-	}
+// Run executes a command, logging it, and returns an error if the command failed.
+func (r *Runner) Run(command string, args ...string) error {
+	_, err := r.Executor.Execute(false, command, args...)
+	return err
 }
 
-// RunIgnoreErr is like Run(..), but doesn't exit on errors
-func RunIgnoreErr(command string, args ...string) {
-	if _, err := runImpl(false, command, args...); err != nil {
+// RunIgnoreErr is like Run(..), but only logs errors
+func (r *Runner) RunIgnoreErr(command string, args ...string) {
+	if err := r.Run(command, args...); err != nil {
 		glog.Warningf("(ignored) %s: %s", command, err)
 	}
 }
 
+// RunGetOutEnv is like RunGetOut(..), but adds env to the environment of
+// the command. The Executor must be an EnvExecutor.
+func (r *Runner) RunGetOutEnv(env []string, command string, args ...string) (string, error) {
+	exe, ok := r.Executor.(EnvExecutor)
+	if !ok {
+		return "", fmt.Errorf("unable to run %s: the executor cannot set environment variables", command)
+	}
+	out, err := exe.ExecuteEnv(true, env, command, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 // RunGetOut is like Run(..), but get the output as a string
-func RunGetOut(command string, args ...string) string {
-	var err error
-	var out []byte
-	if out, err = runImpl(true, command, args...); err != nil {
-		glog.Fatalf("%s: %s", command, err)
+func (r *Runner) RunGetOut(command string, args ...string) (string, error) {
+	out, err := r.Executor.Execute(true, command, args...)
+	if err != nil {
+		return "", err
 	}
-	return strings.TrimSpace(string(out))
+	return strings.TrimSpace(string(out)), nil
 }
@@ -1,42 +1,78 @@
 package utils
 
 import (
-	"testing"
+	"io/ioutil"
 	"os"
+	"testing"
 	"time"
-	"io/ioutil"
 
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
-// TestRun should always pass. The function will panic if it is unable to
-// execute the shell command(s) or the command returns non-zero.
+// TestRun verifies a successful command returns no error and a failing
+// command returns a CommandError with the exit code and stderr.
 func TestRun(t *testing.T) {
-	Run("echo", "echo", "from", "TestRun")
+	r := NewRunner(nil)
+	if err := r.Run("echo", "echo", "from", "TestRun"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	err := r.Run("sh", "-c", "echo oops >&2; exit 3")
+	cmdErr, ok := err.(*CommandError)
+	if !ok {
+		t.Fatalf("Expected a CommandError, got %v", err)
+	}
+	if cmdErr.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", cmdErr.ExitCode)
+	}
+	if cmdErr.Stderr != "oops" {
+		t.Errorf("Expected stderr 'oops', got '%s'", cmdErr.Stderr)
+	}
 }
 
 // TestRunGetOut verifies the output of running a command is
 // its output, trimmed of whitespace.
 func TestRunGetOut(t *testing.T) {
-	if result := RunGetOut("echo", "hello", "world"); result != "hello world" {
+	r := NewRunner(nil)
+	result, err := r.RunGetOut("echo", "hello", "world")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result != "hello world" {
 		t.Errorf("expected 'hello world', got '%s'", result)
 	}
 }
 
+// TestRunGetOutEnv verifies the variables are added to those inherited
+func TestRunGetOutEnv(t *testing.T) {
+	r := NewRunner(nil)
+	result, err := r.RunGetOutEnv([]string{"PIVOT_TEST=a=b"}, "sh", "-c", "echo $PIVOT_TEST $HOME")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := "a=b " + os.Getenv("HOME"); result != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, result)
+	}
+}
+
 // TestRunIgnoreErr verifies the a failed command doesn't cause exit
 func TestRunIgnoreErr(t *testing.T) {
+	r := NewRunner(nil)
 	// Should succeed and cause no exit
-	RunIgnoreErr("echo", "test")
+	r.RunIgnoreErr("echo", "test")
 	// Should fail and cause no exit
-	RunIgnoreErr("acommandthatdoesNOTEXIST")
+	r.RunIgnoreErr("acommandthatdoesNOTEXIST")
 }
 
 // TestRunExt verifies that the wait machinery works, even though we're only
 // just testing a single step here since it's tricky to test retries.
 func TestRunExt(t *testing.T) {
-	RunExt(false, 0, "echo", "echo", "from", "TestRunExt")
+	r := NewRunner(nil)
+	if _, err := r.RunExt(false, 0, "echo", "echo", "from", "TestRunExt"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
-	if result := RunExt(true, 0, "echo", "hello", "world"); result != "hello world" {
+	if result, _ := r.RunExt(true, 0, "echo", "hello", "world"); result != "hello world" {
 		t.Errorf("expected 'hello world', got '%s'", result)
 	}
 
@@ -46,10 +82,13 @@ func TestRunExt(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 	tmpf := tmpdir + "/t"
-	runExtBackoff(false, wait.Backoff{Steps: 6,
+	_, err = r.runExtBackoff(false, wait.Backoff{Steps: 6,
 		Duration: 1 * time.Second,
-		Factor: 1.1},
-		"sh", "-c", "echo -n x >> " + tmpf + " && test $(stat -c '%s' " + tmpf + ") = 3")
+		Factor:   1.1},
+		"sh", "-c", "echo -n x >> "+tmpf+" && test $(stat -c '%s' "+tmpf+") = 3")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 	s, err := os.Stat(tmpf)
 	if err != nil {
 		t.Fatalf("%v", err)
@@ -57,4 +96,34 @@ func TestRunExt(t *testing.T) {
 	if s.Size() != 3 {
 		t.Fatalf("Expected size 3")
 	}
+
+	if _, err := r.RunExt(false, 0, "false"); err == nil {
+		t.Fatalf("Expected an error")
+	}
+}
+
+// TestFakeExecutor verifies scripted responses are returned in order and
+// the last one repeats.
+func TestFakeExecutor(t *testing.T) {
+	fake := NewFakeExecutor().
+		On("podman pull", FakeResponse{ExitCode: 125, Stderr: "timeout"}, FakeResponse{}).
+		On("podman inspect", FakeResponse{Output: "[]\n"})
+	r := NewRunner(fake)
+	r.RetryDelay = 0
+
+	if _, err := r.RunExt(false, 1, "podman", "pull", "-q", "example.com/os:latest"); err != nil {
+		t.Fatalf("Expected pull to succeed on retry, got %v", err)
+	}
+	if len(fake.Calls) != 2 {
+		t.Fatalf("Expected 2 calls, got %v", fake.Calls)
+	}
+	if out, _ := r.RunGetOut("podman", "inspect", "foo"); out != "[]" {
+		t.Errorf("Expected '[]', got '%s'", out)
+	}
+	if !fake.Called("podman inspect foo") {
+		t.Errorf("Expected podman inspect to be recorded")
+	}
+	if fake.Called("podman rmi") {
+		t.Errorf("Did not expect podman rmi to be recorded")
+	}
 }